@@ -0,0 +1,21 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/YehiaGewily/Agent-Mesh/pkg/llm/llmtest"
+)
+
+// mockllm serves the llmtest fake chat-completions API so workers can run
+// with LLM_PROVIDER=openai entirely offline.
+func main() {
+	addr := flag.String("addr", ":8090", "listen address")
+	flag.Parse()
+
+	log.Printf("Mock LLM API listening on %s (base URL http://localhost%s/v1)", *addr, *addr)
+	if err := http.ListenAndServe(*addr, llmtest.NewHandler()); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+}
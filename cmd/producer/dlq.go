@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+	"github.com/YehiaGewily/Agent-Mesh/pkg/broker"
+	"github.com/YehiaGewily/Agent-Mesh/pkg/database"
+)
+
+// DLQItem is a dead-lettered task as returned by the DLQ API. Task is nil if
+// the row no longer exists.
+type DLQItem struct {
+	broker.DLQEntry
+	Task *models.Task `json:"task"`
+}
+
+type ReplayResponse struct {
+	Replayed int      `json:"replayed"`
+	Failed   []string `json:"failed,omitempty"`
+}
+
+type PurgeResponse struct {
+	Purged int `json:"purged"`
+}
+
+// errNotInDLQ is returned when replaying a task that is not dead-lettered.
+var errNotInDLQ = errors.New("task is not in the dead-letter queue")
+
+// deadLetteredStatuses are the statuses a task in the DLQ can have. A DLQ
+// entry whose task has moved on since, e.g. because it was retried, is
+// stale and replaying it resets nothing.
+var deadLetteredStatuses = []models.TaskStatus{
+	models.TaskPermanentFail,
+	models.TaskStatusFailed,
+}
+
+func (p *Producer) registerDLQRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /v1/dlq", p.handleListDLQ)
+	mux.HandleFunc("POST /v1/dlq/replay", p.handleReplayAllDLQ)
+	mux.HandleFunc("POST /v1/dlq/{id}/replay", p.handleReplayDLQ)
+	mux.HandleFunc("DELETE /v1/dlq", p.handlePurgeDLQ)
+	mux.HandleFunc("DELETE /v1/dlq/{id}", p.handleDeleteDLQ)
+}
+
+// ReplayTask gives a task a fresh retry budget and hands it to the outbox
+// relay to be put back on its queue, provided it is in one of the from
+// statuses (any status if none are given). The reset and its outbox entry
+// are committed together, so the task is queued even if this process dies
+// right after.
+func (p *Producer) ReplayTask(ctx context.Context, taskID string, from ...models.TaskStatus) error {
+	task, err := p.DB.ResetTask(ctx, taskID, from...)
+	if err != nil {
+		return fmt.Errorf("db reset failed: %w", err)
+	}
+	if _, err := p.Broker.RemoveFromDLQ(ctx, taskID); err != nil {
+		return fmt.Errorf("dlq remove failed: %w", err)
+	}
+
+	p.dispatch(ctx, task)
+	log.Printf("Task %s replayed", taskID)
+	return nil
+}
+
+func (p *Producer) handleListDLQ(w http.ResponseWriter, r *http.Request) {
+	entries, err := p.Broker.ListDLQ(r.Context())
+	if err != nil {
+		log.Printf("ListDLQ failed: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.TaskID
+	}
+	tasks, err := p.DB.GetTasks(r.Context(), ids)
+	if err != nil {
+		log.Printf("GetTasks failed: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]DLQItem, len(entries))
+	for i, entry := range entries {
+		items[i] = DLQItem{DLQEntry: entry, Task: tasks[entry.TaskID]}
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+func (p *Producer) handleReplayDLQ(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := pathTaskID(w, r)
+	if !ok {
+		return
+	}
+	err := p.replayParked(r.Context(), taskID)
+	switch {
+	case errors.Is(err, errNotInDLQ), errors.Is(err, database.ErrTaskNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("Replay of %s failed: %v", taskID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, ReplayResponse{Replayed: 1})
+}
+
+// replayParked replays taskID only if it is currently dead-lettered.
+func (p *Producer) replayParked(ctx context.Context, taskID string) error {
+	entries, err := p.Broker.ListDLQ(ctx)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.TaskID == taskID {
+			return p.replayDeadLettered(ctx, taskID)
+		}
+	}
+	return errNotInDLQ
+}
+
+// replayDeadLettered replays a task with a DLQ entry, provided the task is
+// still dead-lettered.
+func (p *Producer) replayDeadLettered(ctx context.Context, taskID string) error {
+	err := p.ReplayTask(ctx, taskID, deadLetteredStatuses...)
+	if errors.Is(err, database.ErrTaskConflict) {
+		return errNotInDLQ
+	}
+	return err
+}
+
+func (p *Producer) handleReplayAllDLQ(w http.ResponseWriter, r *http.Request) {
+	entries, err := p.Broker.ListDLQ(r.Context())
+	if err != nil {
+		log.Printf("ListDLQ failed: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	var resp ReplayResponse
+	for _, entry := range entries {
+		if err := p.replayDeadLettered(r.Context(), entry.TaskID); err != nil {
+			log.Printf("Replay of %s failed: %v", entry.TaskID, err)
+			resp.Failed = append(resp.Failed, entry.TaskID)
+			continue
+		}
+		resp.Replayed++
+	}
+	writeJSON(w, http.StatusAccepted, resp)
+}
+
+func (p *Producer) handleDeleteDLQ(w http.ResponseWriter, r *http.Request) {
+	taskID := r.PathValue("id")
+	removed, err := p.Broker.RemoveFromDLQ(r.Context(), taskID)
+	if err != nil {
+		log.Printf("RemoveFromDLQ failed: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, errNotInDLQ.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *Producer) handlePurgeDLQ(w http.ResponseWriter, r *http.Request) {
+	n, err := p.Broker.PurgeDLQ(r.Context())
+	if err != nil {
+		log.Printf("PurgeDLQ failed: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Purged %d tasks from DLQ", n)
+	writeJSON(w, http.StatusOK, PurgeResponse{Purged: n})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
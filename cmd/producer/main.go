@@ -8,27 +8,52 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 
+	"github.com/YehiaGewily/Agent-Mesh/internal/agents"
 	"github.com/YehiaGewily/Agent-Mesh/internal/config"
 	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+	"github.com/YehiaGewily/Agent-Mesh/internal/outbox"
+	"github.com/YehiaGewily/Agent-Mesh/internal/reconciler"
+	"github.com/YehiaGewily/Agent-Mesh/internal/worker"
 	"github.com/YehiaGewily/Agent-Mesh/pkg/broker"
 	"github.com/YehiaGewily/Agent-Mesh/pkg/database"
 	"github.com/YehiaGewily/Agent-Mesh/pkg/notifications"
 )
 
 type Producer struct {
-	Broker *broker.RedisBroker
+	Broker broker.Broker
 	DB     *database.DB
 	Hub    *notifications.Hub
+	Relay  *outbox.Relay
+
+	// AgentTypes are the agent types tasks may be submitted for.
+	AgentTypes []string
+	// IdempotencyWindow is how long an idempotency key dedupes submissions.
+	IdempotencyWindow time.Duration
+	// RetryPolicies supplies the retry policy stored on new tasks.
+	RetryPolicies models.RetryPolicies
 }
 
+// maxIdempotencyKeyLen matches the task_idempotency_keys column width.
+const maxIdempotencyKeyLen = 255
+
 type TaskRequest struct {
 	AgentType string                 `json:"agent_type"`
 	Priority  int                    `json:"priority"`
 	Payload   map[string]interface{} `json:"payload"`
+	// TimeoutSeconds bounds each attempt of the task. Zero uses the agent
+	// type's default.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	// RetryPolicy overrides fields of the agent type's retry policy.
+	RetryPolicy *models.RetryPolicy `json:"retry_policy,omitempty"`
+	// DedupKey is an alternative to the Idempotency-Key header for clients
+	// that cannot set headers.
+	DedupKey string `json:"dedup_key,omitempty"`
 }
 
 type TaskResponse struct {
@@ -48,8 +73,11 @@ func main() {
 	defer db.Close()
 
 	// Initialize Broker
-	redisBroker := broker.NewBroker(cfg.RedisAddr, db)
-	fmt.Printf("Connected to Redis at: %s\n", cfg.RedisAddr)
+	redisBroker, err := broker.New(cfg, db)
+	if err != nil {
+		log.Fatalf("Failed to create broker: %v", err)
+	}
+	fmt.Printf("Connected to Redis at: %s (%s backend)\n", cfg.RedisAddr, cfg.BrokerBackend)
 
 	// Initialize Notification Hub
 	hub := notifications.NewHub()
@@ -58,32 +86,70 @@ func main() {
 	// Subscribe to Redis Updates and broadcast to Hub
 	// Subscribe to Redis Updates and broadcast to Hub
 	go func() {
-		pubsub := redisBroker.SubscribeTaskUpdates(context.Background())
-		defer pubsub.Close()
-		ch := pubsub.Channel()
-		for msg := range ch {
-			hub.Broadcast([]byte(msg.Payload))
+		sub := redisBroker.SubscribeTaskUpdates(context.Background())
+		defer sub.Close()
+		for msg := range sub.Messages() {
+			hub.Broadcast([]byte(msg))
 		}
 	}()
 
 	// Subscribe to System Health and broadcast to Hub
 	// Subscriptions...
 	go func() {
-		pubsub := redisBroker.SubscribeSystemHealth(context.Background())
-		defer pubsub.Close()
-		ch := pubsub.Channel()
-		for msg := range ch {
+		sub := redisBroker.SubscribeSystemHealth(context.Background())
+		defer sub.Close()
+		for msg := range sub.Messages() {
 			wrapper := fmt.Sprintf(`{"type":"HEALTH_UPDATE","data":%s,"timestamp":"%s"}`,
-				msg.Payload,
+				msg,
 				time.Now().Format(time.RFC3339))
 			hub.Broadcast([]byte(wrapper))
 		}
 	}()
 
+	// Forward worker scaling decisions as they are
+	go func() {
+		sub := redisBroker.SubscribeScalingEvents(context.Background())
+		defer sub.Close()
+		for msg := range sub.Messages() {
+			hub.Broadcast([]byte(msg))
+		}
+	}()
+
 	p := &Producer{
-		Broker: redisBroker,
-		DB:     db,
-		Hub:    hub,
+		Broker:            redisBroker,
+		DB:                db,
+		Hub:               hub,
+		Relay:             outbox.NewRelay(db, redisBroker),
+		AgentTypes:        cfg.AgentTypes,
+		IdempotencyWindow: cfg.IdempotencyWindow,
+		RetryPolicies:     cfg.RetryPolicies,
+	}
+
+	// Move stored tasks onto the broker; safe to run in every producer
+	go p.Relay.Run(context.Background())
+
+	// The in-memory broker cannot be reached from other processes, so run
+	// the worker pool in-process instead.
+	if cfg.BrokerBackend == broker.BackendMemory {
+		log.Println("In-memory broker selected, starting embedded workers")
+		w := worker.NewWorker(redisBroker, db)
+		w.Configure(cfg)
+		if w.Agents, err = agents.New(cfg); err != nil {
+			log.Fatalf("Failed to create agents: %v", err)
+		}
+		w.AgentTypes = cfg.WorkerAgentTypes
+		if len(w.AgentTypes) == 0 {
+			w.AgentTypes = w.Agents.Types()
+		}
+		go w.StartHeartbeat(context.Background())
+		go w.StartReaper(context.Background())
+		go w.StartPromoter(context.Background())
+		go w.StartCancelListener(context.Background())
+		go w.StartHealthMonitor(context.Background())
+		go w.Start(context.Background(), cfg.WorkerMinConcurrency, cfg.WorkerMaxConcurrency)
+
+		r := reconciler.NewReconciler(db, redisBroker, cfg.ReconcilePendingAfter, cfg.ReconcileRunningAfter)
+		go r.Run(context.Background(), cfg.ReconcileInterval)
 	}
 
 	// CHECK FOR SIMULATION MODE
@@ -93,7 +159,10 @@ func main() {
 	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/v1/tasks", p.handleCreateTask)
+	mux.HandleFunc("POST /v1/tasks", p.handleCreateTask)
+	p.registerTaskRoutes(mux)
+	p.registerDLQRoutes(mux)
+	p.registerWorkerRoutes(mux)
 	mux.HandleFunc("/v1/ws", func(w http.ResponseWriter, r *http.Request) {
 		p.Hub.ServeWs(w, r)
 	})
@@ -110,7 +179,10 @@ func (p *Producer) startSimulation() {
 	// Note: In Go 1.20+ the global seed is auto-initialized, but for older versions:
 	// rand.Seed(time.Now().UnixNano())
 
-	agentTypes := []string{models.AgentTypeArchitect, models.AgentTypeDeveloper, models.AgentTypeQA}
+	if len(p.AgentTypes) == 0 {
+		log.Println("[SIMULATOR] No agent types configured, not generating tasks")
+		return
+	}
 
 	for {
 		// Random Jitter: 3s to 7s
@@ -118,8 +190,9 @@ func (p *Producer) startSimulation() {
 		time.Sleep(jitter)
 
 		// Create Random Task
-		agentType := agentTypes[rand.Intn(len(agentTypes))]
+		agentType := p.AgentTypes[rand.Intn(len(p.AgentTypes))]
 		priority := rand.Intn(5) + 1 // 1-5
+		policy := p.RetryPolicies.For(agentType)
 
 		task := &models.Task{
 			ID:        uuid.New().String(),
@@ -131,8 +204,9 @@ func (p *Producer) startSimulation() {
 				"ts":     time.Now().Unix(),
 				"note":   "Automated drill",
 			},
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
+			RetryPolicy: &policy,
+			CreatedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
 		}
 
 		if err := p.CreateTask(context.Background(), task); err != nil {
@@ -144,33 +218,47 @@ func (p *Producer) startSimulation() {
 	}
 }
 
-// CreateTask handles persistence, enqueueing, and broadcasting
+// CreateTask handles persistence, enqueueing, and broadcasting. The task is
+// enqueued by the outbox relay, so once it is stored it will run even if the
+// broker is briefly unavailable.
 func (p *Producer) CreateTask(ctx context.Context, task *models.Task) error {
-	// 1. Persist to DB
+	// 1. Persist to DB (with its outbox entry)
 	if err := p.DB.StoreTask(ctx, task); err != nil {
 		return fmt.Errorf("db store failed: %w", err)
 	}
 
-	// 2. Enqueue to Redis
-	if err := p.Broker.Enqueue(ctx, task.ID, task.Priority); err != nil {
-		return fmt.Errorf("redis enqueue failed: %w", err)
+	p.dispatch(ctx, task)
+	return nil
+}
+
+// CreateTaskIdempotent is CreateTask for submissions carrying an idempotency
+// key. If the key was already used within the idempotency window the
+// original task is returned with created set to false and nothing is queued.
+func (p *Producer) CreateTaskIdempotent(ctx context.Context, task *models.Task, key string) (stored *models.Task, created bool, err error) {
+	stored, created, err = p.DB.StoreTaskWithKey(ctx, task, key, p.IdempotencyWindow)
+	if err != nil {
+		return nil, false, fmt.Errorf("db store failed: %w", err)
+	}
+	if !created {
+		return stored, false, nil
 	}
+	p.dispatch(ctx, stored)
+	return stored, true, nil
+}
+
+// dispatch hands a stored task to the outbox relay and announces it.
+func (p *Producer) dispatch(ctx context.Context, task *models.Task) {
+	// 2. Wake the relay so the task is enqueued right away
+	p.Relay.Notify()
 
 	// 3. Broadcast Event
 	if err := p.Broker.PublishTaskEvent(ctx, task); err != nil {
 		log.Printf("Failed to broadcast task event: %v", err)
 		// Non-critical
 	}
-
-	return nil
 }
 
 func (p *Producer) handleCreateTask(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	var req TaskRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
@@ -178,36 +266,72 @@ func (p *Producer) handleCreateTask(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate Agent Type
-	if req.AgentType != models.AgentTypeArchitect &&
-		req.AgentType != models.AgentTypeDeveloper &&
-		req.AgentType != models.AgentTypeQA {
-		http.Error(w, fmt.Sprintf("Invalid agent_type. Must be one of: %s, %s, %s",
-			models.AgentTypeArchitect, models.AgentTypeDeveloper, models.AgentTypeQA), http.StatusBadRequest)
+	if !slices.Contains(p.AgentTypes, req.AgentType) {
+		http.Error(w, fmt.Sprintf("Invalid agent_type. Must be one of: %s",
+			strings.Join(p.AgentTypes, ", ")), http.StatusBadRequest)
+		return
+	}
+	if req.TimeoutSeconds < 0 {
+		http.Error(w, "timeout_seconds must not be negative", http.StatusBadRequest)
 		return
 	}
+	policy := p.RetryPolicies.For(req.AgentType)
+	if req.RetryPolicy != nil {
+		if err := req.RetryPolicy.Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid retry_policy: %v", err), http.StatusBadRequest)
+			return
+		}
+		policy = policy.Merge(*req.RetryPolicy)
+	}
 
 	// Create Task Object
 	task := &models.Task{
-		ID:        uuid.New().String(),
-		Status:    models.TaskStatusPending,
-		Priority:  req.Priority,
-		AgentType: req.AgentType,
-		Payload:   req.Payload,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		ID:             uuid.New().String(),
+		Status:         models.TaskStatusPending,
+		Priority:       req.Priority,
+		AgentType:      req.AgentType,
+		Payload:        req.Payload,
+		TimeoutSeconds: req.TimeoutSeconds,
+		RetryPolicy:    &policy,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+
+	// The header wins over the body so proxies can add keys transparently
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		key = req.DedupKey
+	}
+	if len(key) > maxIdempotencyKeyLen {
+		http.Error(w, fmt.Sprintf("Idempotency key longer than %d characters", maxIdempotencyKeyLen), http.StatusBadRequest)
+		return
 	}
 
 	// Use Shared Logic
-	if err := p.CreateTask(r.Context(), task); err != nil {
+	var err error
+	if key == "" {
+		err = p.CreateTask(r.Context(), task)
+	} else {
+		var created bool
+		task, created, err = p.CreateTaskIdempotent(r.Context(), task, key)
+		if err == nil && !created {
+			log.Printf("Task %s returned for repeated idempotency key", task.ID)
+			w.Header().Set("Idempotent-Replayed", "true")
+			writeJSON(w, http.StatusOK, TaskResponse{
+				ID:     task.ID,
+				Status: string(task.Status),
+			})
+			return
+		}
+	}
+	if err != nil {
 		log.Printf("CreateTask failed: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
 	// Respond
-	w.WriteHeader(http.StatusAccepted)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(TaskResponse{
+	writeJSON(w, http.StatusAccepted, TaskResponse{
 		ID:     task.ID,
 		Status: string(task.Status),
 	})
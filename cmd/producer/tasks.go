@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+	"github.com/YehiaGewily/Agent-Mesh/pkg/database"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// retryableStatuses are the statuses POST /v1/tasks/{id}/retry accepts.
+var retryableStatuses = []models.TaskStatus{
+	models.TaskStatusFailed,
+	models.TaskPermanentFail,
+	models.TaskStatusCancelled,
+}
+
+// TaskDetail is a task together with its attempt history.
+type TaskDetail struct {
+	*models.Task
+	Attempts []models.TaskAttempt `json:"attempts"`
+}
+
+type TaskListResponse struct {
+	Tasks      []*models.Task `json:"tasks"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+func (p *Producer) registerTaskRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /v1/tasks", p.handleListTasks)
+	mux.HandleFunc("GET /v1/tasks/{id}", p.handleGetTask)
+	mux.HandleFunc("GET /v1/tasks/{id}/attempts", p.handleGetTaskAttempts)
+	mux.HandleFunc("POST /v1/tasks/{id}/cancel", p.handleCancelTask)
+	mux.HandleFunc("POST /v1/tasks/{id}/retry", p.handleRetryTask)
+}
+
+// CancelTask cancels a pending or running task. Pending tasks are taken off
+// their queue (or out of retry backoff); running tasks have their agent's
+// context cancelled by the worker holding them.
+func (p *Producer) CancelTask(ctx context.Context, taskID string) (*models.Task, error) {
+	task, err := p.DB.GetTask(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	if task.Status != models.TaskStatusPending && task.Status != models.TaskStatusRunning {
+		return task, database.ErrTaskConflict
+	}
+	moved, err := p.DB.TransitionTask(ctx, taskID, task.Status, models.TaskStatusCancelled)
+	if err != nil {
+		return nil, err
+	}
+	if !moved {
+		// It changed under us; report where it ended up
+		if task, err = p.DB.GetTask(ctx, taskID); err != nil {
+			return nil, err
+		}
+		return task, database.ErrTaskConflict
+	}
+
+	// A worker that fetches it anyway will not claim a cancelled task, and
+	// a worker running it will not retry it
+	if err := p.Broker.Remove(ctx, task); err != nil {
+		log.Printf("Failed to remove cancelled task %s from queue: %v", taskID, err)
+	}
+	if task.Status == models.TaskStatusRunning {
+		if err := p.Broker.PublishCancellation(ctx, taskID); err != nil {
+			log.Printf("Failed to publish cancellation of task %s: %v", taskID, err)
+		}
+	}
+	p.Broker.PublishTaskUpdate(ctx, taskID, string(models.TaskStatusCancelled))
+	log.Printf("Task %s cancelled", taskID)
+	return task, nil
+}
+
+func (p *Producer) handleGetTask(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := pathTaskID(w, r)
+	if !ok {
+		return
+	}
+	task, err := p.DB.GetTask(r.Context(), taskID)
+	if err != nil {
+		writeTaskError(w, "GetTask", err)
+		return
+	}
+	attempts, err := p.DB.GetTaskAttempts(r.Context(), taskID)
+	if err != nil {
+		writeTaskError(w, "GetTaskAttempts", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, TaskDetail{Task: task, Attempts: attempts})
+}
+
+// handleListTasks lists tasks newest first. Supported query parameters are
+// status, agent_type, priority, created_after and created_before (RFC 3339),
+// limit, and cursor, which takes the next_cursor of the previous page.
+func (p *Producer) handleListTasks(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := database.TaskFilter{
+		Status:    models.TaskStatus(q.Get("status")),
+		AgentType: q.Get("agent_type"),
+		Limit:     defaultPageSize,
+	}
+
+	if v := q.Get("priority"); v != "" {
+		priority, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid priority", http.StatusBadRequest)
+			return
+		}
+		filter.Priority = &priority
+	}
+	for name, dst := range map[string]*time.Time{
+		"created_after":  &filter.CreatedAfter,
+		"created_before": &filter.CreatedBefore,
+	} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid %s, expected RFC 3339", name), http.StatusBadRequest)
+				return
+			}
+			*dst = t
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			http.Error(w, fmt.Sprintf("Invalid limit, must be between 1 and %d", maxPageSize), http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+	if v := q.Get("cursor"); v != "" {
+		after, afterID, err := decodeCursor(v)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		filter.After, filter.AfterID = after, afterID
+	}
+
+	tasks, err := p.DB.ListTasks(r.Context(), filter)
+	if err != nil {
+		writeTaskError(w, "ListTasks", err)
+		return
+	}
+
+	resp := TaskListResponse{Tasks: tasks}
+	if len(tasks) == filter.Limit {
+		last := tasks[len(tasks)-1]
+		resp.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (p *Producer) handleCancelTask(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := pathTaskID(w, r)
+	if !ok {
+		return
+	}
+	task, err := p.CancelTask(r.Context(), taskID)
+	if errors.Is(err, database.ErrTaskConflict) {
+		http.Error(w, fmt.Sprintf("Task is %s; only pending or running tasks can be cancelled", task.Status), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeTaskError(w, "CancelTask", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, TaskResponse{ID: taskID, Status: string(models.TaskStatusCancelled)})
+}
+
+func (p *Producer) handleRetryTask(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := pathTaskID(w, r)
+	if !ok {
+		return
+	}
+	if err := p.ReplayTask(r.Context(), taskID, retryableStatuses...); err != nil {
+		if errors.Is(err, database.ErrTaskConflict) {
+			http.Error(w, "Only failed or cancelled tasks can be retried", http.StatusConflict)
+			return
+		}
+		writeTaskError(w, "RetryTask", err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, TaskResponse{ID: taskID, Status: string(models.TaskStatusPending)})
+}
+
+// handleGetTaskAttempts returns the execution history of a task, including
+// the error behind every failed attempt.
+func (p *Producer) handleGetTaskAttempts(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := pathTaskID(w, r)
+	if !ok {
+		return
+	}
+	if _, err := p.DB.GetTask(r.Context(), taskID); err != nil {
+		writeTaskError(w, "GetTask", err)
+		return
+	}
+
+	attempts, err := p.DB.GetTaskAttempts(r.Context(), taskID)
+	if err != nil {
+		writeTaskError(w, "GetTaskAttempts", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, attempts)
+}
+
+// pathTaskID reads the {id} path segment, answering 404 itself if it cannot
+// be a task ID.
+func pathTaskID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	taskID := r.PathValue("id")
+	if _, err := uuid.Parse(taskID); err != nil {
+		http.Error(w, database.ErrTaskNotFound.Error(), http.StatusNotFound)
+		return "", false
+	}
+	return taskID, true
+}
+
+// writeTaskError answers 404 for missing tasks and logs anything else as an
+// internal error of op.
+func writeTaskError(w http.ResponseWriter, op string, err error) {
+	if errors.Is(err, database.ErrTaskNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Printf("%s failed: %v", op, err)
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
+
+// encodeCursor and decodeCursor convert the last task of a page to and from
+// an opaque pagination cursor.
+func encodeCursor(createdAt time.Time, id string) string {
+	raw := createdAt.UTC().Format(time.RFC3339Nano) + "|" + id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return time.Time{}, "", errors.New("malformed cursor")
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return time.Time{}, "", err
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	return createdAt, id, nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+func (p *Producer) registerWorkerRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /v1/workers", p.handleListWorkers)
+}
+
+// handleListWorkers returns every live worker node in the registry.
+func (p *Producer) handleListWorkers(w http.ResponseWriter, r *http.Request) {
+	workers, err := p.Broker.ListWorkers(r.Context())
+	if err != nil {
+		log.Printf("ListWorkers failed: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, workers)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/config"
+	"github.com/YehiaGewily/Agent-Mesh/internal/reconciler"
+	"github.com/YehiaGewily/Agent-Mesh/pkg/broker"
+	"github.com/YehiaGewily/Agent-Mesh/pkg/database"
+)
+
+func main() {
+	cfg := config.Load()
+	fmt.Printf("Starting Reconciler Service...\n")
+
+	if cfg.BrokerBackend == broker.BackendMemory {
+		log.Fatalf("The in-memory broker cannot be reconciled from another process; the producer reconciles it itself")
+	}
+
+	db, err := database.NewConnection(cfg.DBDSN)
+	if err != nil {
+		log.Fatalf("Failed to connect to DB: %v", err)
+	}
+	defer db.Close()
+
+	b, err := broker.New(cfg, db)
+	if err != nil {
+		log.Fatalf("Failed to create broker: %v", err)
+	}
+	fmt.Printf("Connected to Redis at: %s (%s backend)\n", cfg.RedisAddr, cfg.BrokerBackend)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		log.Println("Shutting down reconciler...")
+		cancel()
+	}()
+
+	r := reconciler.NewReconciler(db, b, cfg.ReconcilePendingAfter, cfg.ReconcileRunningAfter)
+	log.Printf("Reconciling every %v", cfg.ReconcileInterval)
+	r.Run(ctx, cfg.ReconcileInterval)
+
+	log.Println("Reconciler Stopped")
+}
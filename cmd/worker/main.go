@@ -6,8 +6,11 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/YehiaGewily/Agent-Mesh/internal/agents"
 	"github.com/YehiaGewily/Agent-Mesh/internal/config"
 	"github.com/YehiaGewily/Agent-Mesh/internal/worker"
 	"github.com/YehiaGewily/Agent-Mesh/pkg/broker"
@@ -27,11 +30,30 @@ func main() {
 	fmt.Printf("Connected to DB\n")
 
 	// 2. Initialize Broker
-	redisBroker := broker.NewBroker(cfg.RedisAddr, db)
-	fmt.Printf("Connected to Redis at: %s\n", cfg.RedisAddr)
+	redisBroker, err := broker.New(cfg, db)
+	if err != nil {
+		log.Fatalf("Failed to create broker: %v", err)
+	}
+	fmt.Printf("Connected to Redis at: %s (%s backend)\n", cfg.RedisAddr, cfg.BrokerBackend)
 
 	// 3. Initialize Worker
 	w := worker.NewWorker(redisBroker, db)
+	if cfg.WorkerName != "" {
+		w.ID = cfg.WorkerName
+	}
+	w.AgentTypes = cfg.WorkerAgentTypes
+	w.Configure(cfg)
+	if w.Agents, err = agents.New(cfg); err != nil {
+		log.Fatalf("Failed to create agents: %v", err)
+	}
+	if len(w.AgentTypes) == 0 {
+		// Only fetch tasks this node has a handler for
+		w.AgentTypes = w.Agents.Types()
+	}
+	log.Printf("Agent handlers: %s (%s)", strings.Join(w.Agents.Types(), ", "), cfg.LLMProvider)
+	log.Printf("Worker node ID: %s (version %s)", w.ID, worker.Version)
+	log.Printf("Memory limit: %d MB (pause at %.0f%%)", w.MemoryLimit>>20, w.MemoryPauseAt*100)
+	log.Printf("Serving agent types: %s", strings.Join(w.AgentTypes, ", "))
 
 	// 4. Start Worker Loop with Graceful Custom
 	ctx, cancel := context.WithCancel(context.Background())
@@ -41,19 +63,39 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// Keep this node's liveness key fresh, reap tasks held by dead nodes and
+	// promote retries whose backoff has elapsed
+	go w.StartHeartbeat(ctx)
+	go w.StartReaper(ctx)
+	go w.StartPromoter(ctx)
+	go w.StartCancelListener(ctx)
+
+	// Start Health Monitor
+	go w.StartHealthMonitor(ctx)
+
+	// Start between the configured minimum and maximum concurrent workers
+	log.Printf("Starting %d-%d concurrent workers...", cfg.WorkerMinConcurrency, cfg.WorkerMaxConcurrency)
+	stopped := make(chan struct{})
 	go func() {
-		<-sigChan
-		log.Println("Shutting down worker...")
-		cancel()
+		w.Start(ctx, cfg.WorkerMinConcurrency, cfg.WorkerMaxConcurrency)
+		close(stopped)
 	}()
 
-	// Start Health Monitor
-	go w.StartHealthMonitor(ctx, 1) // Using ID 1 for single node monitoring for now
+	select {
+	case <-sigChan:
+		// Keep heartbeats and lease renewals going while tasks drain, so
+		// the reaper does not hand them to another node meanwhile
+		log.Println("Shutting down worker...")
+		result := w.Drain(cfg.DrainGracePeriod)
+		log.Printf("Drain finished in %v: %d in flight, %d finished, %d failed, %d handed back",
+			result.Elapsed.Round(time.Millisecond), result.InFlight, result.Finished, result.Failed, result.HandedBack)
+	case <-stopped:
+	}
+	cancel()
 
-	// Start with 5 concurrent workers
-	concurrency := 5
-	log.Printf("Starting %d concurrent workers...", concurrency)
-	w.Start(ctx, concurrency)
+	if err := redisBroker.DeregisterWorker(context.Background(), w.ID); err != nil {
+		log.Printf("Failed to deregister worker: %v", err)
+	}
 
 	log.Println("Worker Stopped")
 }
@@ -0,0 +1,97 @@
+// Package agents defines how workers execute tasks: every agent type is
+// served by a Handler looked up in a Registry.
+package agents
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/config"
+	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+	"github.com/YehiaGewily/Agent-Mesh/pkg/llm"
+)
+
+// Result is what a handler produced for a task.
+type Result struct {
+	// Data is the structured output of the agent.
+	Data map[string]interface{} `json:"data,omitempty"`
+	// Text is an optional free-form rendering of the output.
+	Text string `json:"text,omitempty"`
+}
+
+// Handler executes tasks of one agent type. Handle must return promptly once
+// ctx is done.
+type Handler interface {
+	Handle(ctx context.Context, task *models.Task) (*Result, error)
+}
+
+// HandlerFunc adapts a plain function to Handler.
+type HandlerFunc func(ctx context.Context, task *models.Task) (*Result, error)
+
+func (f HandlerFunc) Handle(ctx context.Context, task *models.Task) (*Result, error) {
+	return f(ctx, task)
+}
+
+// Registry maps agent types to their handlers. It is safe for concurrent
+// use.
+type Registry struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+func NewRegistry() *Registry {
+	return &Registry{handlers: make(map[string]Handler)}
+}
+
+// Register makes h serve agentType, replacing any previous handler.
+func (r *Registry) Register(agentType string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[agentType] = h
+}
+
+// Lookup returns the handler for agentType, if one is registered.
+func (r *Registry) Lookup(agentType string) (Handler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	h, ok := r.handlers[agentType]
+	return h, ok
+}
+
+// Types returns the registered agent types in sorted order.
+func (r *Registry) Types() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	types := make([]string, 0, len(r.handlers))
+	for agentType := range r.handlers {
+		types = append(types, agentType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Supported values for config.Config.LLMProvider.
+const (
+	ProviderSimulated = "simulated"
+	ProviderOpenAI    = "openai"
+)
+
+// New builds the handler registry selected by cfg.LLMProvider.
+func New(cfg *config.Config) (*Registry, error) {
+	switch cfg.LLMProvider {
+	case ProviderSimulated, "":
+		return NewDefaultRegistry(), nil
+	case ProviderOpenAI:
+		return NewLLMRegistry(llm.NewOpenAIClient(llm.OpenAIConfig{
+			BaseURL:    cfg.LLMBaseURL,
+			APIKey:     cfg.LLMAPIKey,
+			Model:      cfg.LLMModel,
+			MaxRetries: cfg.LLMMaxRetries,
+			Timeout:    cfg.LLMTimeout,
+		})), nil
+	default:
+		return nil, fmt.Errorf("unknown llm provider %q", cfg.LLMProvider)
+	}
+}
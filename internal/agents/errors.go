@@ -0,0 +1,84 @@
+package agents
+
+import (
+	"errors"
+	"time"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+)
+
+// Kind tells the worker how to treat a handler failure.
+type Kind int
+
+const (
+	// KindRetryable failures are retried under the task's retry policy.
+	// This is how errors without a kind are treated too.
+	KindRetryable Kind = iota
+	// KindPermanent failures can never succeed and go straight to the DLQ.
+	KindPermanent
+	// KindRateLimited failures are retried, no sooner than RetryAfter.
+	KindRateLimited
+	// KindInvalidInput marks tasks whose payload the handler cannot use.
+	// Like permanent failures they are not retried.
+	KindInvalidInput
+)
+
+// Error is a handler failure of a known kind.
+type Error struct {
+	Kind Kind
+	Err  error
+	// RetryAfter is the minimum wait before retrying a rate-limited task.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Class returns the models.ErrorClass* recorded for this failure.
+func (e *Error) Class() string {
+	switch e.Kind {
+	case KindPermanent:
+		return models.ErrorClassPermanent
+	case KindRateLimited:
+		return models.ErrorClassRateLimited
+	case KindInvalidInput:
+		return models.ErrorClassInvalidInput
+	default:
+		return models.ErrorClassError
+	}
+}
+
+// Retryable wraps err as a failure worth retrying.
+func Retryable(err error) error {
+	return &Error{Kind: KindRetryable, Err: err}
+}
+
+// Permanent wraps err as a failure that retrying cannot fix.
+func Permanent(err error) error {
+	return &Error{Kind: KindPermanent, Err: err}
+}
+
+// RateLimited wraps err as a rate-limit rejection. retryAfter may be zero if
+// the upstream did not say when to come back.
+func RateLimited(err error, retryAfter time.Duration) error {
+	return &Error{Kind: KindRateLimited, Err: err, RetryAfter: retryAfter}
+}
+
+// InvalidInput wraps err as a problem with the task's payload.
+func InvalidInput(err error) error {
+	return &Error{Kind: KindInvalidInput, Err: err}
+}
+
+// RetryAfter returns the minimum wait requested by a rate-limited err.
+func RetryAfter(err error) time.Duration {
+	var agentErr *Error
+	if errors.As(err, &agentErr) {
+		return agentErr.RetryAfter
+	}
+	return 0
+}
@@ -0,0 +1,91 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+	"github.com/YehiaGewily/Agent-Mesh/pkg/llm"
+)
+
+// Role prompts for the built-in agent types.
+const (
+	architectPrompt = "You are a software architect. Analyse the request and propose a system design: components, data flow, and trade-offs."
+	developerPrompt = "You are a senior software developer. Implement the request and reply with the code and a short explanation."
+	qaPrompt        = "You are a QA engineer. Design a test plan for the request and list concrete test cases, including edge cases."
+)
+
+// NewLLMRegistry returns a registry serving the built-in agent types with
+// handlers that call provider.
+func NewLLMRegistry(provider llm.Provider) *Registry {
+	r := NewRegistry()
+	r.Register(models.AgentTypeArchitect, LLM(provider, architectPrompt))
+	r.Register(models.AgentTypeDeveloper, LLM(provider, developerPrompt))
+	r.Register(models.AgentTypeQA, LLM(provider, qaPrompt))
+	return r
+}
+
+// LLM returns a handler that sends the task to provider with the given
+// system prompt. The user message is the payload's "prompt" string if it has
+// one, or else the whole payload as JSON.
+func LLM(provider llm.Provider, systemPrompt string) Handler {
+	return HandlerFunc(func(ctx context.Context, task *models.Task) (*Result, error) {
+		prompt, err := taskPrompt(task)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := provider.Chat(ctx, llm.ChatRequest{
+			Messages: []llm.Message{
+				{Role: llm.RoleSystem, Content: systemPrompt},
+				{Role: llm.RoleUser, Content: prompt},
+			},
+		})
+		if err != nil {
+			return nil, classifyLLMError(fmt.Errorf("model call failed: %w", err))
+		}
+
+		return &Result{
+			Data: map[string]interface{}{
+				"model":         resp.Model,
+				"finish_reason": resp.FinishReason,
+				"usage":         resp.Usage,
+			},
+			Text: resp.Content,
+		}, nil
+	})
+}
+
+func taskPrompt(task *models.Task) (string, error) {
+	if prompt, ok := task.Payload["prompt"].(string); ok && prompt != "" {
+		return prompt, nil
+	}
+	data, err := json.Marshal(task.Payload)
+	if err != nil {
+		return "", InvalidInput(fmt.Errorf("failed to encode payload: %w", err))
+	}
+	return string(data), nil
+}
+
+// classifyLLMError maps provider errors onto handler error kinds. Requests
+// the provider rejects outright will be rejected again, so they are not
+// retried.
+func classifyLLMError(err error) error {
+	var apiErr *llm.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch {
+	case apiErr.StatusCode == http.StatusTooManyRequests:
+		return RateLimited(err, apiErr.RetryAfter)
+	case apiErr.StatusCode == http.StatusBadRequest, apiErr.StatusCode == http.StatusUnprocessableEntity:
+		return InvalidInput(err)
+	case apiErr.StatusCode == http.StatusUnauthorized, apiErr.StatusCode == http.StatusForbidden, apiErr.StatusCode == http.StatusNotFound:
+		return Permanent(err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,55 @@
+package agents
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+	"github.com/YehiaGewily/Agent-Mesh/pkg/llm"
+)
+
+func TestClassifyLLMError(t *testing.T) {
+	plain := errors.New("connection reset")
+	tests := []struct {
+		name       string
+		err        error
+		class      string
+		retryAfter time.Duration
+	}{
+		{"rate limited", &llm.APIError{StatusCode: http.StatusTooManyRequests, RetryAfter: 3 * time.Second}, models.ErrorClassRateLimited, 3 * time.Second},
+		{"bad request", &llm.APIError{StatusCode: http.StatusBadRequest}, models.ErrorClassInvalidInput, 0},
+		{"unprocessable", &llm.APIError{StatusCode: http.StatusUnprocessableEntity}, models.ErrorClassInvalidInput, 0},
+		{"unauthorized", &llm.APIError{StatusCode: http.StatusUnauthorized}, models.ErrorClassPermanent, 0},
+		{"forbidden", &llm.APIError{StatusCode: http.StatusForbidden}, models.ErrorClassPermanent, 0},
+		{"unknown model", &llm.APIError{StatusCode: http.StatusNotFound}, models.ErrorClassPermanent, 0},
+		{"server error", &llm.APIError{StatusCode: http.StatusInternalServerError}, "", 0},
+		{"transport error", plain, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyLLMError(tt.err)
+			if !errors.Is(got, tt.err) {
+				t.Errorf("classified error %v does not wrap %v", got, tt.err)
+			}
+			var agentErr *Error
+			if tt.class == "" {
+				if errors.As(got, &agentErr) {
+					t.Errorf("got %s error, want it unclassified", agentErr.Class())
+				}
+				return
+			}
+			if !errors.As(got, &agentErr) {
+				t.Fatalf("got unclassified error %v, want %s", got, tt.class)
+			}
+			if agentErr.Class() != tt.class {
+				t.Errorf("got class %s, want %s", agentErr.Class(), tt.class)
+			}
+			if RetryAfter(got) != tt.retryAfter {
+				t.Errorf("got retry after %v, want %v", RetryAfter(got), tt.retryAfter)
+			}
+		})
+	}
+}
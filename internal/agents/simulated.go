@@ -0,0 +1,52 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+)
+
+// simulatedWorkDuration is how long a simulated agent pretends to think.
+const simulatedWorkDuration = 2 * time.Second
+
+// NewDefaultRegistry returns a registry serving the built-in agent types
+// with simulated handlers.
+func NewDefaultRegistry() *Registry {
+	r := NewRegistry()
+	r.Register(models.AgentTypeArchitect, Simulated("Starting System Architecture Analysis..."))
+	r.Register(models.AgentTypeDeveloper, Simulated("Writing Code Implementation..."))
+	r.Register(models.AgentTypeQA, Simulated("Running Test Suite..."))
+	return r
+}
+
+// Simulated returns a handler that logs activity, waits a moment and
+// succeeds, unless the task payload sets "simulate_fail": true fails with a
+// retryable error, and "permanent" or "panic" fail the matching way.
+func Simulated(activity string) Handler {
+	return HandlerFunc(func(ctx context.Context, task *models.Task) (*Result, error) {
+		log.Printf("[Agent %s] %s", task.AgentType, activity)
+
+		select {
+		case <-time.After(simulatedWorkDuration):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		switch val := task.Payload["simulate_fail"]; val {
+		case true:
+			return nil, fmt.Errorf("simulated AI agent error")
+		case "permanent":
+			return nil, Permanent(fmt.Errorf("simulated permanent AI agent error"))
+		case "panic":
+			panic("simulated AI agent panic")
+		}
+
+		return &Result{
+			Data: map[string]interface{}{"simulated": true},
+			Text: fmt.Sprintf("%s finished task %s", task.AgentType, task.ID),
+		}, nil
+	})
+}
@@ -1,19 +1,184 @@
 package config
 
 import (
+	"encoding/json"
+	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/models"
 )
 
 type Config struct {
 	RedisAddr string
 	DBDSN     string
+
+	// BrokerBackend selects the broker implementation: "lists" (default),
+	// "streams" or "memory".
+	BrokerBackend string
+
+	// LeaseTimeout is how long a claimed task stays invisible to other
+	// workers without its lease being renewed.
+	LeaseTimeout time.Duration
+	// PriorityAgingRate is how many priority levels a waiting task gains per
+	// minute. Zero disables aging (strict priority order).
+	PriorityAgingRate float64
+	// DequeueMode is "strict" (default) to always serve the highest
+	// effective priority first, or "weighted" to draw from the high, medium
+	// and low bands in proportion to DequeueWeights.
+	DequeueMode    string
+	DequeueWeights []int
+
+	// AgentTypes lists the agent types the producer accepts tasks for.
+	AgentTypes []string
+
+	// IdempotencyWindow is how long an Idempotency-Key maps to the task it
+	// created; repeat submissions within it return that task.
+	IdempotencyWindow time.Duration
+
+	// LLMProvider selects the agent handlers workers run: "simulated"
+	// (default) or "openai" for any OpenAI-compatible chat-completions API
+	// at LLMBaseURL.
+	LLMProvider   string
+	LLMBaseURL    string
+	LLMAPIKey     string
+	LLMModel      string
+	LLMMaxRetries int
+	LLMTimeout    time.Duration
+
+	// TaskTimeout bounds a single attempt of a task that sets no timeout of
+	// its own. AgentTimeouts overrides it per agent type. Zero, the default,
+	// means no limit.
+	TaskTimeout   time.Duration
+	AgentTimeouts map[string]time.Duration
+
+	// RetryPolicies decides how failed tasks are retried, per agent type.
+	// The producer stores the resolved policy on each task so every worker
+	// retries it the same way.
+	RetryPolicies models.RetryPolicies
+
+	// MemoryLimitMB is the worker's RSS budget. Zero uses the container's
+	// memory limit, or 512 MB outside a container. Workers stop fetching
+	// once RSS reaches MemoryPauseAt of it and resume at MemoryResumeAt;
+	// FreeOSMemory makes paused workers return freed memory to the OS.
+	MemoryLimitMB  int
+	MemoryPauseAt  float64
+	MemoryResumeAt float64
+	FreeOSMemory   bool
+
+	// WorkerMinConcurrency and WorkerMaxConcurrency bound the number of
+	// task slots per worker node. The node starts at the minimum and
+	// reconsiders its size every ScaleInterval.
+	WorkerMinConcurrency int
+	WorkerMaxConcurrency int
+	ScaleInterval        time.Duration
+
+	// DrainGracePeriod is how long a stopping worker lets in-flight tasks
+	// finish before handing them back to the queue.
+	DrainGracePeriod time.Duration
+
+	// ReconcileInterval is how often the reconciler compares Postgres with
+	// the broker. Pending tasks untouched for ReconcilePendingAfter and
+	// running tasks untouched for ReconcileRunningAfter are requeued if the
+	// broker has lost them.
+	ReconcileInterval     time.Duration
+	ReconcilePendingAfter time.Duration
+	ReconcileRunningAfter time.Duration
+
+	// WorkerName is this worker node's ID. It must be unique across the
+	// mesh; if empty the node is named after its hostname and PID.
+	WorkerName string
+
+	// WorkerAgentTypes restricts a worker node to the listed agent types.
+	// Empty means the node serves every type.
+	WorkerAgentTypes []string
 }
 
 func Load() *Config {
 	return &Config{
-		RedisAddr: getEnv("REDIS_ADDR", "localhost:6379"),
-		DBDSN:     getEnv("DB_DSN", "user=user password=123456 host=localhost port=5432 dbname=agentmesh sslmode=disable"),
+		RedisAddr:     getEnv("REDIS_ADDR", "localhost:6379"),
+		DBDSN:         getEnv("DB_DSN", "user=user password=123456 host=localhost port=5432 dbname=agentmesh sslmode=disable"),
+		BrokerBackend: getEnv("BROKER_BACKEND", "lists"),
+		LeaseTimeout:  getEnvDuration("LEASE_TIMEOUT", 30*time.Second),
+
+		PriorityAgingRate: getEnvFloat("PRIORITY_AGING_RATE", 1),
+		DequeueMode:       getEnv("DEQUEUE_MODE", "strict"),
+		DequeueWeights:    getEnvInts("DEQUEUE_WEIGHTS", ":", []int{6, 3, 1}),
+
+		AgentTypes: getEnvList("AGENT_TYPES", ",", []string{models.AgentTypeArchitect, models.AgentTypeDeveloper, models.AgentTypeQA}),
+
+		IdempotencyWindow: getEnvDuration("IDEMPOTENCY_WINDOW", 24*time.Hour),
+
+		LLMProvider:   getEnv("LLM_PROVIDER", "simulated"),
+		LLMBaseURL:    getEnv("LLM_BASE_URL", "https://api.openai.com/v1"),
+		LLMAPIKey:     getEnv("LLM_API_KEY", ""),
+		LLMModel:      getEnv("LLM_MODEL", "gpt-4o-mini"),
+		LLMMaxRetries: getEnvInt("LLM_MAX_RETRIES", 3),
+		LLMTimeout:    getEnvDuration("LLM_TIMEOUT", 2*time.Minute),
+
+		TaskTimeout:   getEnvDuration("TASK_TIMEOUT", 0),
+		AgentTimeouts: getEnvDurations("AGENT_TIMEOUTS", ",", nil),
+
+		RetryPolicies: loadRetryPolicies(),
+
+		MemoryLimitMB:  getEnvInt("MEMORY_LIMIT_MB", 0),
+		MemoryPauseAt:  getEnvFloat("MEMORY_PAUSE_AT", 0.9),
+		MemoryResumeAt: getEnvFloat("MEMORY_RESUME_AT", 0.75),
+		FreeOSMemory:   getEnvBool("MEMORY_FREE_OS", false),
+
+		WorkerMinConcurrency: getEnvInt("WORKER_MIN_CONCURRENCY", 2),
+		WorkerMaxConcurrency: getEnvInt("WORKER_MAX_CONCURRENCY", 10),
+		ScaleInterval:        getEnvDuration("SCALE_INTERVAL", 10*time.Second),
+
+		DrainGracePeriod: getEnvDuration("DRAIN_GRACE_PERIOD", 30*time.Second),
+
+		ReconcileInterval:     getEnvDuration("RECONCILE_INTERVAL", 1*time.Minute),
+		ReconcilePendingAfter: getEnvDuration("RECONCILE_PENDING_AFTER", 2*time.Minute),
+		ReconcileRunningAfter: getEnvDuration("RECONCILE_RUNNING_AFTER", 10*time.Minute),
+
+		WorkerName:       getEnv("WORKER_NAME", ""),
+		WorkerAgentTypes: getEnvList("WORKER_AGENT_TYPES", ",", nil),
+	}
+}
+
+// loadRetryPolicies builds the default policy from the RETRY_* variables
+// and applies the per-agent-type overrides in AGENT_RETRY_POLICIES, a JSON
+// object such as {"ARCHITECT": {"max_attempts": 3}}.
+func loadRetryPolicies() models.RetryPolicies {
+	def := models.DefaultRetryPolicy
+	def.MaxAttempts = getEnvInt("RETRY_MAX_ATTEMPTS", def.MaxAttempts)
+	def.BaseDelaySeconds = getEnvDuration("RETRY_BASE_DELAY", time.Duration(def.BaseDelaySeconds*float64(time.Second))).Seconds()
+	def.MaxDelaySeconds = getEnvDuration("RETRY_MAX_DELAY", time.Duration(def.MaxDelaySeconds*float64(time.Second))).Seconds()
+	def.Multiplier = getEnvFloat("RETRY_MULTIPLIER", def.Multiplier)
+	def.Jitter = getEnvFloat("RETRY_JITTER", def.Jitter)
+	def.RetryableClasses = getEnvList("RETRY_CLASSES", ",", def.RetryableClasses)
+	if err := def.Validate(); err != nil {
+		log.Printf("Invalid RETRY_* policy, using defaults: %v", err)
+		def = models.DefaultRetryPolicy
+	}
+
+	policies := models.RetryPolicies{Default: def}
+	value, exists := os.LookupEnv("AGENT_RETRY_POLICIES")
+	if !exists {
+		return policies
 	}
+	var overrides map[string]models.RetryPolicy
+	if err := json.Unmarshal([]byte(value), &overrides); err != nil {
+		log.Printf("Invalid AGENT_RETRY_POLICIES %q, using defaults: %v", value, err)
+		return policies
+	}
+	policies.ByAgentType = make(map[string]models.RetryPolicy, len(overrides))
+	for agentType, override := range overrides {
+		policy := def.Merge(override)
+		if err := policy.Validate(); err != nil {
+			log.Printf("Invalid retry policy for %s in AGENT_RETRY_POLICIES, using the default: %v", agentType, err)
+			continue
+		}
+		policies.ByAgentType[agentType] = policy
+	}
+	return policies
 }
 
 func getEnv(key, fallback string) string {
@@ -22,3 +187,110 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration %q for %s, using %v", value, key, fallback)
+		return fallback
+	}
+	return d
+}
+
+// getEnvDurations parses a list of TYPE=duration pairs, e.g.
+// "ARCHITECT=20m,QA_ENGINEER=2m".
+func getEnvDurations(key, sep string, fallback map[string]time.Duration) map[string]time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	durations := make(map[string]time.Duration)
+	for _, part := range strings.Split(value, sep) {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		name, raw, ok := strings.Cut(part, "=")
+		d, err := time.ParseDuration(strings.TrimSpace(raw))
+		if !ok || err != nil {
+			log.Printf("Invalid duration list %q for %s, using %v", value, key, fallback)
+			return fallback
+		}
+		durations[strings.TrimSpace(name)] = d
+	}
+	return durations
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Invalid number %q for %s, using %v", value, key, fallback)
+		return fallback
+	}
+	return f
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid boolean %q for %s, using %v", value, key, fallback)
+		return fallback
+	}
+	return b
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid number %q for %s, using %v", value, key, fallback)
+		return fallback
+	}
+	return n
+}
+
+func getEnvInts(key, sep string, fallback []int) []int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	parts := strings.Split(value, sep)
+	ints := make([]int, 0, len(parts))
+	for _, part := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			log.Printf("Invalid list %q for %s, using %v", value, key, fallback)
+			return fallback
+		}
+		ints = append(ints, n)
+	}
+	return ints
+}
+
+func getEnvList(key, sep string, fallback []string) []string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	var items []string
+	for _, part := range strings.Split(value, sep) {
+		if part = strings.TrimSpace(part); part != "" {
+			items = append(items, part)
+		}
+	}
+	return items
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"slices"
+	"time"
+)
+
+// RetryPolicy decides whether and when a failed task runs again. Delays are
+// in seconds so policies read naturally in JSON. Zero fields in a per-task
+// override inherit from the agent type's policy (see Merge).
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts int `json:"max_attempts,omitempty"`
+	// The n-th retry waits BaseDelaySeconds * Multiplier^(n-1), capped at
+	// MaxDelaySeconds and spread by up to ±Jitter of itself.
+	BaseDelaySeconds float64 `json:"base_delay_seconds,omitempty"`
+	MaxDelaySeconds  float64 `json:"max_delay_seconds,omitempty"`
+	Multiplier       float64 `json:"multiplier,omitempty"`
+	Jitter           float64 `json:"jitter,omitempty"`
+	// RetryableClasses lists the error classes worth retrying; failures of
+	// any other class go straight to the dead-letter queue.
+	RetryableClasses []string `json:"retryable_classes,omitempty"`
+}
+
+// DefaultRetryPolicy retries errors, timeouts and rate limits five times,
+// waiting 2s, 4s, 8s, 16s and 32s.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:      6,
+	BaseDelaySeconds: 2,
+	MaxDelaySeconds:  60,
+	Multiplier:       2,
+	RetryableClasses: []string{ErrorClassError, ErrorClassTimeout, ErrorClassRateLimited},
+}
+
+// Merge returns p with the non-zero fields of override applied.
+func (p RetryPolicy) Merge(override RetryPolicy) RetryPolicy {
+	if override.MaxAttempts != 0 {
+		p.MaxAttempts = override.MaxAttempts
+	}
+	if override.BaseDelaySeconds != 0 {
+		p.BaseDelaySeconds = override.BaseDelaySeconds
+	}
+	if override.MaxDelaySeconds != 0 {
+		p.MaxDelaySeconds = override.MaxDelaySeconds
+	}
+	if override.Multiplier != 0 {
+		p.Multiplier = override.Multiplier
+	}
+	if override.Jitter != 0 {
+		p.Jitter = override.Jitter
+	}
+	if override.RetryableClasses != nil {
+		p.RetryableClasses = slices.Clone(override.RetryableClasses)
+	}
+	return p
+}
+
+// Validate rejects policies with out-of-range fields.
+func (p RetryPolicy) Validate() error {
+	switch {
+	case p.MaxAttempts < 0:
+		return errors.New("max_attempts must not be negative")
+	case p.BaseDelaySeconds < 0 || p.MaxDelaySeconds < 0:
+		return errors.New("delays must not be negative")
+	case p.Multiplier != 0 && p.Multiplier < 1:
+		return errors.New("multiplier must be at least 1")
+	case p.Jitter < 0 || p.Jitter > 1:
+		return errors.New("jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// Retryable reports whether a failure of the given error class may be
+// retried. Permanent and invalid-input failures never are.
+func (p RetryPolicy) Retryable(class string) bool {
+	switch class {
+	case ErrorClassPermanent, ErrorClassInvalidInput:
+		return false
+	}
+	return slices.Contains(p.RetryableClasses, class)
+}
+
+// Delay returns how long to wait before the given retry, counting from 1.
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	seconds := p.BaseDelaySeconds * math.Pow(multiplier, float64(retry-1))
+	if p.MaxDelaySeconds > 0 && seconds > p.MaxDelaySeconds {
+		seconds = p.MaxDelaySeconds
+	}
+	if p.Jitter > 0 {
+		seconds *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// RetryPolicies holds the retry policy of every agent type.
+type RetryPolicies struct {
+	Default     RetryPolicy
+	ByAgentType map[string]RetryPolicy
+}
+
+// For returns the policy for agentType, falling back to the default.
+func (r RetryPolicies) For(agentType string) RetryPolicy {
+	if policy, ok := r.ByAgentType[agentType]; ok {
+		return policy
+	}
+	return r.Default
+}
@@ -16,6 +16,7 @@ const (
 	TaskStatusRunning   TaskStatus = "running"
 	TaskStatusCompleted TaskStatus = "completed"
 	TaskStatusFailed    TaskStatus = "failed"
+	TaskStatusCancelled TaskStatus = "cancelled"
 	TaskPermanentFail   TaskStatus = "PERMANENT_FAILURE"
 )
 
@@ -26,14 +27,108 @@ type Task struct {
 	AgentType  string                 `json:"agent_type"`
 	Payload    map[string]interface{} `json:"payload"`
 	RetryCount int                    `json:"retry_count"`
-	CreatedAt  time.Time              `json:"created_at"`
-	UpdatedAt  time.Time              `json:"updated_at"`
+	// TimeoutSeconds bounds each attempt; zero uses the agent type's
+	// default.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	// RetryPolicy is fixed when the task is submitted. Tasks stored without
+	// one use the executing worker's policy for their agent type.
+	RetryPolicy *RetryPolicy `json:"retry_policy,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+
+	// Result and ResultText hold the agent's output once the task completes.
+	Result     map[string]interface{} `json:"result,omitempty"`
+	ResultText string                 `json:"result_text,omitempty"`
+	// WorkerID is the worker slot that last ran the task.
+	WorkerID   string     `json:"worker_id,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	// LastError and LastErrorClass describe the most recent failed attempt.
+	LastError      string `json:"last_error,omitempty"`
+	LastErrorClass string `json:"last_error_class,omitempty"`
+}
+
+// Error classes recorded on failed attempts.
+const (
+	ErrorClassError     = "error"
+	ErrorClassTimeout   = "timeout"
+	ErrorClassCancelled = "cancelled"
+	// ErrorClassUnsupported marks tasks no registered agent can run.
+	ErrorClassUnsupported = "unsupported"
+	// Classes of failures handlers report through typed errors.
+	ErrorClassPermanent    = "permanent"
+	ErrorClassRateLimited  = "rate_limited"
+	ErrorClassInvalidInput = "invalid_input"
+	// ErrorClassPanic marks attempts that panicked.
+	ErrorClassPanic = "panic"
+)
+
+// TaskAttempt is one execution of a task by a worker.
+type TaskAttempt struct {
+	TaskID       string     `json:"task_id"`
+	Attempt      int        `json:"attempt"`
+	WorkerID     string     `json:"worker_id"`
+	Status       TaskStatus `json:"status"`
+	ErrorMessage string     `json:"error_message,omitempty"`
+	ErrorClass   string     `json:"error_class,omitempty"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
 }
+
+// Worker node states reported in the worker registry.
+const (
+	WorkerStatusRunning  = "running"
+	WorkerStatusDraining = "draining"
+	// WorkerStatusPaused nodes have stopped fetching for memory pressure.
+	WorkerStatusPaused = "paused"
+)
+
+// WorkerInfo is a worker node's entry in the worker registry.
+type WorkerInfo struct {
+	ID       string `json:"id"`
+	Hostname string `json:"hostname"`
+	PID      int    `json:"pid"`
+	Version  string `json:"version"`
+	Status   string `json:"status"`
+	// Concurrency is the current number of task slots, kept between
+	// MinConcurrency and MaxConcurrency; Running is how many are busy.
+	Concurrency    int `json:"concurrency"`
+	MinConcurrency int `json:"min_concurrency"`
+	MaxConcurrency int `json:"max_concurrency"`
+	Running        int `json:"running"`
+	// AgentTypes are the agent types the node fetches. Empty means all.
+	AgentTypes    []string  `json:"agent_types"`
+	StartedAt     time.Time `json:"started_at"`
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+}
+
 type SystemHealth struct {
 	ReqType   string  `json:"type"` // "HEALTH_METRIC"
-	WorkerID  int     `json:"worker_id"`
+	WorkerID  string  `json:"worker_id"`
 	CPUUsage  float64 `json:"cpu_usage"`
-	RAMUsage  float64 `json:"ram_usage"` // Used Percent of Soft Limit (512MB)
+	RAMUsage  float64 `json:"ram_usage"` // Used Percent of the memory limit
 	RAMUsedMB float64 `json:"ram_used_mb"`
-	Timestamp string  `json:"timestamp"`
+	// RAMLimitMB is the node's memory limit; Paused reports whether it has
+	// stopped fetching because RSS is too close to it.
+	RAMLimitMB float64 `json:"ram_limit_mb"`
+	Paused     bool    `json:"paused"`
+	// Concurrency is the node's current number of task slots; Running is
+	// how many are busy.
+	Concurrency int    `json:"concurrency"`
+	Running     int    `json:"running"`
+	Timestamp   string `json:"timestamp"`
+}
+
+// ScalingEvent records a worker node changing its concurrency.
+type ScalingEvent struct {
+	ReqType    string  `json:"type"` // "SCALING_EVENT"
+	WorkerID   string  `json:"worker_id"`
+	From       int     `json:"from"`
+	To         int     `json:"to"`
+	Reason     string  `json:"reason"`
+	QueueDepth int     `json:"queue_depth"`
+	CPUUsage   float64 `json:"cpu_usage"`
+	RAMUsage   float64 `json:"ram_usage"`
+	Timestamp  string  `json:"timestamp"`
 }
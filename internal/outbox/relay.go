@@ -0,0 +1,101 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+	"github.com/YehiaGewily/Agent-Mesh/pkg/broker"
+	"github.com/YehiaGewily/Agent-Mesh/pkg/database"
+)
+
+const (
+	// pollInterval is how often the relay looks for unsent entries when it
+	// is not woken up by Notify.
+	pollInterval = 1 * time.Second
+	// batchSize caps how many entries a single transaction relays.
+	batchSize = 100
+	// pruneInterval is how often sent entries older than retention are
+	// deleted.
+	pruneInterval = 1 * time.Hour
+	retention     = 24 * time.Hour
+)
+
+// Relay moves tasks from the Postgres outbox onto the broker. Together with
+// StoreTask writing the outbox entry in the same transaction as the task,
+// this gives at-least-once enqueue semantics: a task may be enqueued twice if
+// the relay dies between Enqueue and marking the entry sent, but a stored
+// task is never left unqueued.
+type Relay struct {
+	DB     *database.DB
+	Broker broker.Broker
+
+	wake chan struct{}
+}
+
+func NewRelay(db *database.DB, b broker.Broker) *Relay {
+	return &Relay{
+		DB:     db,
+		Broker: b,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Notify asks the relay to look at the outbox now rather than at the next
+// poll. It never blocks.
+func (r *Relay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run relays outbox entries until ctx is cancelled.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	lastPrune := time.Now()
+
+	for {
+		r.flush(ctx)
+
+		if time.Since(lastPrune) >= pruneInterval {
+			lastPrune = time.Now()
+			if n, err := r.DB.PruneOutbox(ctx, retention); err != nil {
+				log.Printf("[Outbox] Prune error: %v", err)
+			} else if n > 0 {
+				log.Printf("[Outbox] Pruned %d sent entries", n)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// flush relays batches until the outbox is drained or a batch fails.
+func (r *Relay) flush(ctx context.Context) {
+	for {
+		sent, seen, err := r.DB.RelayOutbox(ctx, batchSize, func(task *models.Task) error {
+			return r.Broker.Enqueue(ctx, task)
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("[Outbox] Relay error: %v", err)
+			}
+			return
+		}
+		if seen > sent {
+			log.Printf("[Outbox] %d of %d tasks could not be enqueued; will retry", seen-sent, seen)
+			return
+		}
+		if seen < batchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+	"github.com/YehiaGewily/Agent-Mesh/pkg/broker"
+	"github.com/YehiaGewily/Agent-Mesh/pkg/database"
+)
+
+// batchSize caps how many tasks of each status a single pass examines.
+const batchSize = 500
+
+// Reconciler repairs drift between Postgres and the broker: pending tasks the
+// broker no longer holds (e.g. after Redis lost its data) are enqueued again,
+// and running tasks whose worker vanished without the broker noticing are
+// returned to pending and enqueued.
+type Reconciler struct {
+	DB     *database.DB
+	Broker broker.Broker
+
+	// PendingAfter and RunningAfter are how long a task must sit unchanged
+	// in that status before it is checked, which keeps the reconciler clear
+	// of tasks that are merely in transit.
+	PendingAfter time.Duration
+	RunningAfter time.Duration
+}
+
+// Result summarizes one reconciliation pass.
+type Result struct {
+	Checked  int
+	Requeued int
+}
+
+func NewReconciler(db *database.DB, b broker.Broker, pendingAfter, runningAfter time.Duration) *Reconciler {
+	return &Reconciler{
+		DB:           db,
+		Broker:       b,
+		PendingAfter: pendingAfter,
+		RunningAfter: runningAfter,
+	}
+}
+
+// Run reconciles every interval until ctx is cancelled.
+func (r *Reconciler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		res, err := r.Reconcile(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("[Reconciler] Error: %v", err)
+		} else if res.Requeued > 0 {
+			log.Printf("[Reconciler] Requeued %d of %d stale tasks", res.Requeued, res.Checked)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile runs a single pass over stale pending and running tasks.
+func (r *Reconciler) Reconcile(ctx context.Context) (Result, error) {
+	var res Result
+	for _, status := range []models.TaskStatus{models.TaskStatusPending, models.TaskStatusRunning} {
+		after := r.PendingAfter
+		if status == models.TaskStatusRunning {
+			after = r.RunningAfter
+		}
+
+		tasks, err := r.DB.FindStaleTasks(ctx, status, time.Now().Add(-after), batchSize)
+		if err != nil {
+			return res, err
+		}
+		if len(tasks) == 0 {
+			continue
+		}
+		tracked, err := r.Broker.Tracked(ctx, tasks)
+		if err != nil {
+			return res, err
+		}
+
+		res.Checked += len(tasks)
+		for _, task := range tasks {
+			if tracked[task.ID] {
+				continue
+			}
+			repaired, err := r.repair(ctx, task)
+			if err != nil {
+				return res, err
+			}
+			if repaired {
+				res.Requeued++
+			}
+		}
+	}
+	return res, nil
+}
+
+// repair puts a task the broker has lost back on its queue. The status
+// change is conditional so a worker finishing the task concurrently wins.
+func (r *Reconciler) repair(ctx context.Context, task *models.Task) (bool, error) {
+	moved, err := r.DB.TransitionTask(ctx, task.ID, task.Status, models.TaskStatusPending)
+	if err != nil {
+		return false, err
+	}
+	if !moved {
+		return false, nil
+	}
+	if err := r.Broker.Enqueue(ctx, task); err != nil {
+		return false, fmt.Errorf("failed to requeue task %s: %w", task.ID, err)
+	}
+
+	log.Printf("[Reconciler] Task %s was %s but missing from the broker; requeued", task.ID, task.Status)
+	task.Status = models.TaskStatusPending
+	task.UpdatedAt = time.Now()
+	if err := r.Broker.PublishTaskEvent(ctx, task); err != nil {
+		log.Printf("[Reconciler] Failed to broadcast repair of %s: %v", task.ID, err)
+	}
+	return true, nil
+}
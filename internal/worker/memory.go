@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultMemoryLimit is the RSS budget used when neither the config nor
+	// the container sets one.
+	defaultMemoryLimit = 512 << 20
+	// Default fractions of the memory limit at which slots stop and resume
+	// fetching.
+	defaultMemoryPauseAt  = 0.9
+	defaultMemoryResumeAt = 0.75
+)
+
+// cgroup files holding the container memory limit, for cgroup v2 and v1.
+var cgroupMemoryFiles = []string{
+	"/sys/fs/cgroup/memory.max",
+	"/sys/fs/cgroup/memory/memory.limit_in_bytes",
+}
+
+// CgroupMemoryLimit returns the memory limit of the container this process
+// runs in, if it has one.
+func CgroupMemoryLimit() (uint64, bool) {
+	for _, path := range cgroupMemoryFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		value := strings.TrimSpace(string(data))
+		if value == "max" {
+			return 0, false
+		}
+		limit, err := strconv.ParseUint(value, 10, 64)
+		// cgroup v1 reports "unlimited" as a huge page-aligned number
+		if err != nil || limit >= 1<<62 {
+			return 0, false
+		}
+		return limit, true
+	}
+	return 0, false
+}
+
+// resolveMemoryLimit returns the configured limit in MB if set, else the
+// container's limit, else defaultMemoryLimit.
+func resolveMemoryLimit(configuredMB int) uint64 {
+	if configuredMB > 0 {
+		return uint64(configuredMB) << 20
+	}
+	if limit, ok := CgroupMemoryLimit(); ok {
+		return limit
+	}
+	return defaultMemoryLimit
+}
+
+// Paused reports whether the node has stopped fetching because of memory
+// pressure.
+func (w *Worker) Paused() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.resumed != nil
+}
+
+// waitForMemory blocks while the node is paused for memory pressure. It
+// reports false if ctx is done first.
+func (w *Worker) waitForMemory(ctx context.Context) bool {
+	w.mu.Lock()
+	resumed := w.resumed
+	w.mu.Unlock()
+	if resumed == nil {
+		return true
+	}
+
+	select {
+	case <-resumed:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// checkMemory pauses fetching once rss reaches MemoryPauseAt of the limit
+// and resumes it when rss falls back to MemoryResumeAt. While paused it
+// optionally forces the memory back to the OS.
+func (w *Worker) checkMemory(rss uint64) {
+	if w.MemoryLimit == 0 {
+		return
+	}
+	limit := float64(w.MemoryLimit)
+	usage := float64(rss) / limit
+
+	w.mu.Lock()
+	paused := w.resumed != nil
+	switch {
+	case !paused && usage >= w.MemoryPauseAt:
+		w.resumed = make(chan struct{})
+		log.Printf("[Memory %s] RSS %.0f MB is %.0f%% of the %.0f MB limit; pausing task fetching",
+			w.ID, float64(rss)/(1<<20), usage*100, limit/(1<<20))
+	case paused && usage <= w.MemoryResumeAt:
+		close(w.resumed)
+		w.resumed = nil
+		log.Printf("[Memory %s] RSS back down to %.0f MB; resuming task fetching", w.ID, float64(rss)/(1<<20))
+	}
+	paused = w.resumed != nil
+	w.mu.Unlock()
+
+	if paused && w.FreeOSMemory {
+		debug.FreeOSMemory()
+	}
+}
@@ -0,0 +1,133 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+)
+
+const (
+	// defaultScaleInterval is how often the node reconsiders its
+	// concurrency.
+	defaultScaleInterval = 10 * time.Second
+	// The node only adds a slot while CPU and memory use (percent of the
+	// memory limit) are below scaleUpBelow, and drops one when either
+	// reaches scaleDownAbove.
+	scaleUpBelow   = 70.0
+	scaleDownAbove = 85.0
+)
+
+// addSlotLocked starts another fetch loop. w.mu must be held.
+func (w *Worker) addSlotLocked(ctx context.Context) {
+	slotCtx, stop := context.WithCancel(ctx)
+	workerID := w.nextSlot
+	w.nextSlot++
+	w.slotStops = append(w.slotStops, stop)
+	w.concurrency = len(w.slotStops)
+
+	w.slots.Add(1)
+	go func() {
+		defer w.slots.Done()
+		w.loop(slotCtx, workerID)
+	}()
+}
+
+// removeSlotLocked stops the newest fetch loop once its current task, if
+// any, is finished. w.mu must be held.
+func (w *Worker) removeSlotLocked() {
+	last := len(w.slotStops) - 1
+	w.slotStops[last]()
+	w.slotStops = w.slotStops[:last]
+	w.concurrency = len(w.slotStops)
+}
+
+// autoscale resizes the pool between the Start bounds every ScaleInterval
+// until ctx is cancelled.
+func (w *Worker) autoscale(ctx context.Context) {
+	interval := w.ScaleInterval
+	if interval <= 0 {
+		interval = defaultScaleInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.scale(ctx)
+		}
+	}
+}
+
+// scale applies one scaling decision, logging and broadcasting it if the
+// pool size changes.
+func (w *Worker) scale(ctx context.Context) {
+	depth, err := w.Broker.QueueDepth(ctx, w.AgentTypes...)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("[Scaler %s] Failed to read queue depth: %v", w.ID, err)
+		}
+		return
+	}
+
+	w.mu.Lock()
+	from := w.concurrency
+	health := w.health
+	delta, reason := w.scaleDecision(from, len(w.running), depth, health, w.resumed != nil)
+	switch {
+	case delta > 0 && ctx.Err() == nil:
+		w.addSlotLocked(ctx)
+	case delta < 0:
+		w.removeSlotLocked()
+	default:
+		w.mu.Unlock()
+		return
+	}
+	to := w.concurrency
+	w.mu.Unlock()
+
+	log.Printf("[Scaler %s] Concurrency %d -> %d: %s", w.ID, from, to, reason)
+	event := &models.ScalingEvent{
+		ReqType:    "SCALING_EVENT",
+		WorkerID:   w.ID,
+		From:       from,
+		To:         to,
+		Reason:     reason,
+		QueueDepth: depth,
+		Timestamp:  time.Now().Format(time.RFC3339),
+	}
+	if health != nil {
+		event.CPUUsage = health.CPUUsage
+		event.RAMUsage = health.RAMUsage
+	}
+	if err := w.Broker.PublishScalingEvent(ctx, event); err != nil {
+		log.Printf("[Scaler %s] Failed to publish scaling event: %v", w.ID, err)
+	}
+}
+
+// scaleDecision returns +1 to add a slot, -1 to drop one or 0 to stay put,
+// with the reason. It shrinks under memory or CPU pressure or when slots sit
+// idle, and grows only when every slot is busy, tasks are waiting and the
+// latest health sample shows headroom. w.mu must be held.
+func (w *Worker) scaleDecision(current, running, depth int, health *models.SystemHealth, paused bool) (int, string) {
+	canShrink := current > w.minConcurrency
+	switch {
+	case paused && canShrink:
+		return -1, "fetching paused for memory pressure"
+	case health != nil && health.CPUUsage >= scaleDownAbove && canShrink:
+		return -1, fmt.Sprintf("CPU at %.0f%%", health.CPUUsage)
+	case health != nil && health.RAMUsage >= scaleDownAbove && canShrink:
+		return -1, fmt.Sprintf("memory at %.0f%% of the limit", health.RAMUsage)
+	case depth == 0 && running < current && canShrink:
+		return -1, fmt.Sprintf("queue empty with %d idle slot(s)", current-running)
+	case depth > 0 && running >= current && current < w.maxConcurrency &&
+		health != nil && !paused && health.CPUUsage < scaleUpBelow && health.RAMUsage < scaleUpBelow:
+		return 1, fmt.Sprintf("%d task(s) waiting with every slot busy", depth)
+	}
+	return 0, ""
+}
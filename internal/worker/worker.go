@@ -2,50 +2,384 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"math"
 	"os"
+	"runtime/debug"
 	"sync"
 	"time"
 
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/process"
 
+	"github.com/YehiaGewily/Agent-Mesh/internal/agents"
+	"github.com/YehiaGewily/Agent-Mesh/internal/config"
 	"github.com/YehiaGewily/Agent-Mesh/internal/models"
 	"github.com/YehiaGewily/Agent-Mesh/pkg/broker"
 	"github.com/YehiaGewily/Agent-Mesh/pkg/database"
 )
 
+const (
+	// heartbeatInterval is how often the node refreshes its liveness key.
+	heartbeatInterval = 5 * time.Second
+	// heartbeatTTL is how long a node is considered alive without a heartbeat.
+	heartbeatTTL = 3 * heartbeatInterval
+	// promoteInterval is how often the node moves due retries back onto
+	// their queue.
+	promoteInterval = 1 * time.Second
+	// reapInterval is how often the node looks for dead nodes' in-flight
+	// tasks and expired leases.
+	reapInterval = 10 * time.Second
+)
+
+// Version identifies the build in the worker registry. Release builds set it
+// with -ldflags "-X github.com/YehiaGewily/Agent-Mesh/internal/worker.Version=...".
+var Version = "dev"
+
 type Worker struct {
-	Broker *broker.RedisBroker
+	// ID identifies this node to the broker; its in-flight tasks are tracked
+	// under this name.
+	ID     string
+	Broker broker.Broker
 	DB     *database.DB
+	// AgentTypes limits the tasks this node fetches to the given agent
+	// types. Empty means every type.
+	AgentTypes []string
+	// Agents holds the handler for every agent type this node can run.
+	// Tasks of other types are dead-lettered.
+	Agents *agents.Registry
+	// DefaultTimeout bounds an attempt of a task that sets no timeout of its
+	// own; AgentTimeouts overrides it per agent type. Zero means no limit.
+	DefaultTimeout time.Duration
+	AgentTimeouts  map[string]time.Duration
+	// RetryPolicies is used for tasks stored without a retry policy.
+	RetryPolicies models.RetryPolicies
+
+	// MemoryLimit is the RSS budget of the node in bytes. Slots stop
+	// fetching once RSS reaches MemoryPauseAt of it and start again when it
+	// falls back to MemoryResumeAt. If FreeOSMemory is set the node forces
+	// a GC and returns freed memory to the OS while paused.
+	MemoryLimit    uint64
+	MemoryPauseAt  float64
+	MemoryResumeAt float64
+	FreeOSMemory   bool
+	// ScaleInterval is how often the node reconsiders its concurrency when
+	// Start is given a range.
+	ScaleInterval time.Duration
+
+	mu sync.Mutex
+	// running maps the IDs of tasks being processed on this node to the
+	// function that cancels their context.
+	running map[string]context.CancelCauseFunc
+	// stopFetching ends the fetch loops started by Start, and stopped is
+	// closed once Start returns.
+	stopFetching context.CancelFunc
+	stopped      chan struct{}
+	// slots tracks the running fetch loops; slotStops stops each one, newest
+	// last.
+	slots     sync.WaitGroup
+	slotStops []context.CancelFunc
+	nextSlot  int
+
+	// Every task context derives from abortCtx, so Drain can interrupt the
+	// tasks still running once its grace period is over.
+	abortCtx   context.Context
+	abortTasks context.CancelCauseFunc
+	// drained tallies how the tasks processed during a drain ended.
+	drained [numOutcomes]int
+
+	startedAt   time.Time
+	concurrency int
+	// minConcurrency and maxConcurrency bound the pool size chosen by the
+	// scaler.
+	minConcurrency int
+	maxConcurrency int
+	draining       bool
+	// health is the latest sample taken by StartHealthMonitor.
+	health *models.SystemHealth
+	// resumed is non-nil while fetching is paused for memory pressure and
+	// is closed when it resumes.
+	resumed chan struct{}
+}
+
+// DrainResult describes how a Drain went.
+type DrainResult struct {
+	// InFlight is how many tasks were running while the node drained.
+	// Those not counted below were left for the reaper and reconciler.
+	InFlight int
+	// Finished tasks completed, or were cancelled, within the grace period.
+	Finished int
+	// Failed tasks ended in an error and were scheduled for a retry or
+	// dead-lettered.
+	Failed int
+	// HandedBack tasks were interrupted and returned to their queue.
+	HandedBack int
+	Elapsed    time.Duration
 }
 
-func NewWorker(b *broker.RedisBroker, db *database.DB) *Worker {
+// taskOutcome is how processing left a task.
+type taskOutcome int
+
+const (
+	// outcomeLeft tasks are still running as far as Postgres knows.
+	outcomeLeft taskOutcome = iota
+	outcomeFinished
+	outcomeFailed
+	outcomeHandedBack
+	numOutcomes
+)
+
+var (
+	// errCancelledByRequest is the cause attached to a task's context when
+	// it is cancelled through the API.
+	errCancelledByRequest = errors.New("task cancelled by request")
+	// errDraining is the cause attached to a task's context when the node
+	// shuts down before the task finishes.
+	errDraining = errors.New("worker shutting down")
+)
+
+func NewWorker(b broker.Broker, db *database.DB) *Worker {
+	abortCtx, abortTasks := context.WithCancelCause(context.Background())
 	return &Worker{
+		ID:     nodeID(),
 		Broker: b,
 		DB:     db,
+		Agents: agents.NewDefaultRegistry(),
+		RetryPolicies: models.RetryPolicies{
+			Default: models.DefaultRetryPolicy,
+		},
+		running:    make(map[string]context.CancelCauseFunc),
+		abortCtx:   abortCtx,
+		abortTasks: abortTasks,
+		startedAt:  time.Now(),
+
+		MemoryLimit:    resolveMemoryLimit(0),
+		MemoryPauseAt:  defaultMemoryPauseAt,
+		MemoryResumeAt: defaultMemoryResumeAt,
+	}
+}
+
+// Configure applies the execution, retry and memory settings in cfg.
+func (w *Worker) Configure(cfg *config.Config) {
+	w.DefaultTimeout = cfg.TaskTimeout
+	w.AgentTimeouts = cfg.AgentTimeouts
+	w.RetryPolicies = cfg.RetryPolicies
+	w.MemoryLimit = resolveMemoryLimit(cfg.MemoryLimitMB)
+	w.MemoryPauseAt = cfg.MemoryPauseAt
+	w.MemoryResumeAt = cfg.MemoryResumeAt
+	w.FreeOSMemory = cfg.FreeOSMemory
+	w.ScaleInterval = cfg.ScaleInterval
+}
+
+// nodeID derives a name for this process that is unique across the mesh.
+func nodeID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "worker"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// Start runs fetch loops until ctx is cancelled or Drain is called. It
+// starts minConcurrency loops; if maxConcurrency is larger the pool grows and
+// shrinks within that range as queue depth and resource headroom allow.
+// Cancelling ctx only stops fetching: tasks already running are finished
+// before Start returns.
+func (w *Worker) Start(ctx context.Context, minConcurrency, maxConcurrency int) {
+	minConcurrency = max(minConcurrency, 1)
+	maxConcurrency = max(maxConcurrency, minConcurrency)
+
+	w.mu.Lock()
+	ctx, w.stopFetching = context.WithCancel(ctx)
+	w.stopped = make(chan struct{})
+	w.minConcurrency, w.maxConcurrency = minConcurrency, maxConcurrency
+	for i := 0; i < minConcurrency; i++ {
+		w.addSlotLocked(ctx)
 	}
+	stopped := w.stopped
+	w.mu.Unlock()
+	defer close(stopped)
+
+	if maxConcurrency > minConcurrency {
+		w.autoscale(ctx)
+	} else {
+		<-ctx.Done()
+	}
+	w.slots.Wait()
 }
 
-func (w *Worker) Start(ctx context.Context, concurrency int) {
-	var wg sync.WaitGroup
-	for i := 0; i < concurrency; i++ {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
-			w.loop(ctx, workerID)
-		}(i)
+// Drain shuts the node down in two phases. It stops fetching new tasks and
+// gives the ones in flight up to grace to finish; whatever is still running
+// after that is interrupted and handed back to its queue for another node.
+// Drain returns once every task has been finished or handed back.
+func (w *Worker) Drain(grace time.Duration) DrainResult {
+	start := time.Now()
+	w.mu.Lock()
+	inFlight := len(w.running)
+	w.draining = true
+	if w.stopFetching != nil {
+		w.stopFetching()
+	}
+	done := w.stopped
+	w.mu.Unlock()
+	log.Printf("[Drain %s] Stopped fetching; waiting up to %v for %d task(s)", w.ID, grace, inFlight)
+
+	if done == nil {
+		// Start was never called, so nothing is running
+		done = make(chan struct{})
+		close(done)
+	}
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		w.mu.Lock()
+		remaining := len(w.running)
+		w.mu.Unlock()
+		log.Printf("[Drain %s] Grace period over; handing back %d unfinished task(s)", w.ID, remaining)
+		w.abortTasks(errDraining)
+		<-done
+	}
+
+	w.mu.Lock()
+	drained := w.drained
+	w.mu.Unlock()
+	total := 0
+	for _, n := range drained {
+		total += n
+	}
+	return DrainResult{
+		InFlight:   total,
+		Finished:   drained[outcomeFinished],
+		Failed:     drained[outcomeFailed],
+		HandedBack: drained[outcomeHandedBack],
+		Elapsed:    time.Since(start),
 	}
-	wg.Wait()
 }
 
-func (w *Worker) StartHealthMonitor(ctx context.Context, workerID int) {
+// Info describes this node for the worker registry.
+func (w *Worker) Info() *models.WorkerInfo {
+	hostname, _ := os.Hostname()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	status := models.WorkerStatusRunning
+	switch {
+	case w.draining:
+		status = models.WorkerStatusDraining
+	case w.resumed != nil:
+		status = models.WorkerStatusPaused
+	}
+	return &models.WorkerInfo{
+		ID:             w.ID,
+		Hostname:       hostname,
+		PID:            os.Getpid(),
+		Version:        Version,
+		Status:         status,
+		Concurrency:    w.concurrency,
+		MinConcurrency: w.minConcurrency,
+		MaxConcurrency: w.maxConcurrency,
+		Running:        len(w.running),
+		AgentTypes:     w.AgentTypes,
+		StartedAt:      w.startedAt,
+		LastHeartbeat:  time.Now(),
+	}
+}
+
+// StartHeartbeat keeps this node's liveness key fresh so the reaper leaves its
+// in-flight tasks alone, and keeps its worker registry entry up to date.
+func (w *Worker) StartHeartbeat(ctx context.Context) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.Broker.Heartbeat(ctx, w.ID, heartbeatTTL); err != nil && ctx.Err() == nil {
+			log.Printf("[Heartbeat %s] Error: %v", w.ID, err)
+		}
+		if err := w.Broker.RegisterWorker(ctx, w.Info(), heartbeatTTL); err != nil && ctx.Err() == nil {
+			log.Printf("[Heartbeat %s] Error updating worker registry: %v", w.ID, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// StartCancelListener stops tasks running on this node when their
+// cancellation is published.
+func (w *Worker) StartCancelListener(ctx context.Context) {
+	sub := w.Broker.SubscribeCancellations(ctx)
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case taskID, ok := <-sub.Messages():
+			if !ok {
+				return
+			}
+			w.mu.Lock()
+			cancel, running := w.running[taskID]
+			w.mu.Unlock()
+			if running {
+				log.Printf("[Cancel %s] Cancelling task %s", w.ID, taskID)
+				cancel(errCancelledByRequest)
+			}
+		}
+	}
+}
+
+// StartReaper periodically returns tasks held by dead nodes to their queues.
+func (w *Worker) StartReaper(ctx context.Context) {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reaped, err := w.Broker.ReapOrphans(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("[Reaper] Error reaping orphaned tasks: %v", err)
+			}
+			if reaped > 0 {
+				log.Printf("[Reaper] Re-queued %d orphaned task(s)", reaped)
+			}
+		}
+	}
+}
+
+// StartPromoter periodically moves scheduled retries whose backoff has
+// elapsed back onto their priority queue.
+func (w *Worker) StartPromoter(ctx context.Context) {
+	ticker := time.NewTicker(promoteInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := w.Broker.PromoteDue(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("[Promoter] Error promoting scheduled tasks: %v", err)
+			}
+		}
+	}
+}
+
+func (w *Worker) StartHealthMonitor(ctx context.Context) {
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 
-	log.Printf("[HealthMonitor %d] Started", workerID)
+	log.Printf("[HealthMonitor %s] Started", w.ID)
 
 	for {
 		select {
@@ -63,13 +397,15 @@ func (w *Worker) StartHealthMonitor(ctx context.Context, workerID int) {
 			proc, err := process.NewProcess(int32(os.Getpid()))
 			var ramMb float64
 			var ramPercent float64
+			limitMb := float64(w.MemoryLimit) / 1024 / 1024
 
 			if err == nil {
 				memInfo, err := proc.MemoryInfo()
 				if err == nil {
 					ramMb = float64(memInfo.RSS) / 1024 / 1024
-					// 512MB Soft Limit for bar calculation
-					ramPercent = (ramMb / 512.0) * 100
+					ramPercent = (ramMb / limitMb) * 100
+					// Apply backpressure before the node runs out of memory
+					w.checkMemory(memInfo.RSS)
 				} else {
 					log.Printf("Error getting process memory: %v", err)
 				}
@@ -78,13 +414,21 @@ func (w *Worker) StartHealthMonitor(ctx context.Context, workerID int) {
 			}
 
 			health := &models.SystemHealth{
-				ReqType:   "HEALTH_METRIC",
-				WorkerID:  workerID,
-				CPUUsage:  cpuPercent[0],
-				RAMUsage:  ramPercent,
-				RAMUsedMB: ramMb,
-				Timestamp: time.Now().Format(time.RFC3339),
+				ReqType:    "HEALTH_METRIC",
+				WorkerID:   w.ID,
+				CPUUsage:   cpuPercent[0],
+				RAMUsage:   ramPercent,
+				RAMUsedMB:  ramMb,
+				RAMLimitMB: limitMb,
+				Paused:     w.Paused(),
+				Timestamp:  time.Now().Format(time.RFC3339),
 			}
+			w.mu.Lock()
+			health.Concurrency = w.concurrency
+			health.Running = len(w.running)
+			// Keep the sample for the scaler
+			w.health = health
+			w.mu.Unlock()
 
 			if err := w.Broker.PublishSystemHealth(ctx, health); err != nil {
 				log.Printf("Error publishing health: %v", err)
@@ -101,8 +445,14 @@ func (w *Worker) loop(ctx context.Context, workerID int) {
 			log.Printf("[Worker %d] Stopping", workerID)
 			return
 		default:
+			// Hold off while the node is short of memory
+			if !w.waitForMemory(ctx) {
+				log.Printf("[Worker %d] Stopping", workerID)
+				return
+			}
+
 			// Fetch task (blocking)
-			taskID, err := w.Broker.FetchTask(ctx)
+			taskID, err := w.Broker.FetchTask(ctx, w.ID, w.AgentTypes...)
 			if err != nil {
 				// Don't spam logs if it's just a timeout or context cancel
 				if ctx.Err() != nil {
@@ -113,43 +463,144 @@ func (w *Worker) loop(ctx context.Context, workerID int) {
 				continue
 			}
 
-			w.processTask(ctx, workerID, taskID)
+			// A fetched task is seen through even if fetching stops; only
+			// Drain may interrupt it
+			w.process(context.WithoutCancel(ctx), workerID, taskID)
 		}
 	}
 }
 
-func (w *Worker) processTask(ctx context.Context, workerID int, taskID string) {
+// process runs processTask, turning a panic into a permanent failure of the
+// task so one bad task cannot bring the node down.
+func (w *Worker) process(ctx context.Context, workerID int, taskID string) {
+	outcome := outcomeLeft
+	defer func() {
+		if r := recover(); r != nil {
+			reason := fmt.Sprintf("panic: %v\n%s", r, debug.Stack())
+			log.Printf("[Worker %d] Recovered from panic processing task %s: %s", workerID, taskID, reason)
+			if _, err := w.DB.RecordFailure(ctx, taskID, reason, models.ErrorClassPanic); err != nil {
+				log.Printf("[Worker %d] Failed to record panic of %s: %v", workerID, taskID, err)
+			}
+			w.deadLetter(ctx, workerID, &models.Task{ID: taskID}, reason)
+			outcome = outcomeFailed
+		}
+		w.countOutcome(outcome)
+	}()
+	outcome = w.processTask(ctx, workerID, taskID)
+}
+
+// countOutcome tallies how a task ended if the node is draining.
+func (w *Worker) countOutcome(outcome taskOutcome) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.draining {
+		w.drained[outcome]++
+	}
+}
+
+func (w *Worker) processTask(ctx context.Context, workerID int, taskID string) taskOutcome {
 	// 1. Get Task Details
 	task, err := w.DB.GetTask(ctx, taskID)
 	if err != nil {
+		// Leave the task in flight; it is reaped if this node goes away.
 		log.Printf("[Worker %d] Failed to get task %s details: %v", workerID, taskID, err)
-		return
+		return outcomeLeft
 	}
 
+	// Every outcome below either finishes the task or re-enqueues it, so it
+	// no longer belongs in our in-flight list afterwards.
+	defer func() {
+		if err := w.Broker.Ack(ctx, w.ID, taskID); err != nil {
+			log.Printf("[Worker %d] Failed to ack task %s: %v", workerID, taskID, err)
+		}
+	}()
+
+	// Keep the lease alive while we hold the task
+	leaseCtx, stopRenewing := context.WithCancel(ctx)
+	defer stopRenewing()
+	go w.renewLease(leaseCtx, workerID, taskID)
+
+	// The agent runs under its own context so the task can be cancelled
+	// without touching the rest of the node
+	taskCtx, cancelTask := context.WithCancelCause(w.abortCtx)
+	defer cancelTask(nil)
+	w.mu.Lock()
+	w.running[taskID] = cancelTask
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		delete(w.running, taskID)
+		w.mu.Unlock()
+	}()
+
 	log.Printf("[Worker %d] Processing task %s for %s (Priority: %d)", workerID, task.ID, task.AgentType, task.Priority)
 
-	switch task.AgentType {
-	case models.AgentTypeArchitect:
-		log.Printf("[Worker %d] Starting System Architecture Analysis...", workerID)
-	case models.AgentTypeDeveloper:
-		log.Printf("[Worker %d] Writing Code Implementation...", workerID)
-	case models.AgentTypeQA:
-		log.Printf("[Worker %d] Running Test Suite...", workerID)
+	// Record the attempt; a missing history entry must not stop the work
+	slotID := fmt.Sprintf("%s/%d", w.ID, workerID)
+	startedAt := time.Now()
+	if err := w.DB.StartTask(ctx, task.ID, slotID); err != nil {
+		log.Printf("[Worker %d] Failed to record start of %s: %v", workerID, task.ID, err)
 	}
+	task.WorkerID = slotID
+	task.StartedAt = &startedAt
+	task.Result, task.ResultText, task.FinishedAt = nil, "", nil
 
-	// 2. Simulate AI Processing
-	err = w.simulateAIWork(task)
+	attempt, err := w.DB.StartAttempt(ctx, task.ID, slotID)
+	if err != nil {
+		log.Printf("[Worker %d] Failed to record attempt for %s: %v", workerID, task.ID, err)
+	}
+	finishAttempt := func(status models.TaskStatus, errMsg, errClass string) {
+		if attempt == 0 {
+			return
+		}
+		if err := w.DB.FinishAttempt(ctx, task.ID, attempt, status, errMsg, errClass); err != nil {
+			log.Printf("[Worker %d] Failed to record outcome of attempt %d for %s: %v", workerID, attempt, task.ID, err)
+		}
+	}
+
+	// 2. Run the agent registered for this type
+	handler, ok := w.Agents.Lookup(task.AgentType)
+	if !ok {
+		reason := fmt.Sprintf("no handler registered for agent type %s", task.AgentType)
+		log.Printf("[Worker %d] Task %s rejected: %s. Moving to DLQ.", workerID, task.ID, reason)
+		finishAttempt(models.TaskStatusFailed, reason, models.ErrorClassUnsupported)
+		w.deadLetter(ctx, workerID, task, reason)
+		return outcomeFailed
+	}
+	timeout := w.timeoutFor(task)
+	result, err := w.runHandler(taskCtx, handler, task, timeout)
+
+	if errors.Is(context.Cause(taskCtx), errCancelledByRequest) {
+		// The producer already marked it cancelled; just stop here
+		finishAttempt(models.TaskStatusCancelled, errCancelledByRequest.Error(), models.ErrorClassCancelled)
+		log.Printf("[Worker %d] Task %s cancelled", workerID, task.ID)
+		return outcomeFinished
+	}
+	if errors.Is(context.Cause(taskCtx), errDraining) {
+		finishAttempt(models.TaskStatusCancelled, errDraining.Error(), models.ErrorClassCancelled)
+		return w.handBack(ctx, workerID, task)
+	}
 
 	if err == nil {
 		// Success
+		finishAttempt(models.TaskStatusCompleted, "", "")
+		if result == nil {
+			result = &agents.Result{}
+		}
 		now := time.Now()
-		if err := w.DB.UpdateTaskStatus(ctx, task.ID, models.TaskStatusCompleted); err != nil {
+		if moved, err := w.DB.FinishTask(ctx, task.ID, models.TaskStatusCompleted, result.Data, result.Text); err != nil {
 			log.Printf("[Worker %d] Failed to mark task %s completed: %v", workerID, task.ID, err)
+		} else if !moved {
+			log.Printf("[Worker %d] Task %s was cancelled while running; discarding result", workerID, task.ID)
+			return outcomeFinished
 		}
 
 		// Update struct for broadcast
 		task.Status = models.TaskStatusCompleted
 		task.UpdatedAt = now
+		task.FinishedAt = &now
+		task.Result = result.Data
+		task.ResultText = result.Text
 
 		// Broadcast Completion Event
 		if err := w.Broker.PublishTaskEvent(ctx, task); err != nil {
@@ -157,57 +608,203 @@ func (w *Worker) processTask(ctx context.Context, workerID int, taskID string) {
 		}
 
 		log.Printf("[Worker %d] Task %s completed successfully", workerID, task.ID)
-		return
+		return outcomeFinished
 	}
 
 	// Failure Handling
 	log.Printf("[Worker %d] Task %s failed: %v", workerID, task.ID, err)
+	reason, class, retryAfter := err.Error(), errorClass(err), agents.RetryAfter(err)
+	var panicked *panicError
+	if errors.As(err, &panicked) {
+		// Keep the stack trace with the task for whoever investigates it
+		reason = fmt.Sprintf("%s\n%s", reason, panicked.stack)
+	}
+	finishAttempt(models.TaskStatusFailed, reason, class)
 
-	newRetryCount, err := w.DB.IncrementRetryCount(ctx, task.ID)
+	newRetryCount, err := w.DB.RecordFailure(ctx, task.ID, reason, class)
 	if err != nil {
-		log.Printf("[Worker %d] Failed to increment retry count for %s: %v", workerID, task.ID, err)
+		log.Printf("[Worker %d] Failed to record failure of %s: %v", workerID, task.ID, err)
 		// Try to at least fail it locally or proceed to backoff if possible?
 		// If DB is down, we are in trouble.
 	}
 
-	if newRetryCount > 5 {
+	policy := w.retryPolicy(task)
+	if !policy.Retryable(class) {
+		log.Printf("[Worker %d] Task %s failed with non-retryable error class %s. Moving to DLQ.", workerID, task.ID, class)
+		w.deadLetter(ctx, workerID, task, reason)
+	} else if newRetryCount >= policy.MaxAttempts {
 		// DLQ
-		log.Printf("[Worker %d] Task %s exceeded max retries (%d). Moving to DLQ.", workerID, task.ID, newRetryCount)
-		if err := w.Broker.AddToDLQ(ctx, task.ID); err != nil {
-			log.Printf("[Worker %d] Failed to add %s to DLQ: %v", workerID, task.ID, err)
-		}
-		if err := w.DB.UpdateTaskStatus(ctx, taskID, models.TaskPermanentFail); err != nil {
-			log.Printf("[Worker %d] Failed to mark %s as PERMANENT_FAILURE: %v", workerID, task.ID, err)
-		}
+		log.Printf("[Worker %d] Task %s exhausted its %d attempts. Moving to DLQ.", workerID, task.ID, policy.MaxAttempts)
+		w.deadLetter(ctx, workerID, task, reason)
 	} else {
-		// Exponential Backoff
-		backoffDuration := time.Duration(math.Pow(2, float64(newRetryCount))) * time.Second
+		// Backoff via the broker's delayed set, so this slot is free for
+		// other work while the task waits (keep original priority)
+		backoffDuration := policy.Delay(newRetryCount)
+		if retryAfter > backoffDuration {
+			// The upstream asked us to stay away for longer
+			backoffDuration = retryAfter
+		}
 		log.Printf("[Worker %d] Re-queueing task %s in %v", workerID, task.ID, backoffDuration)
 
-		time.Sleep(backoffDuration)
-
-		// Re-enqueue (keep original priority)
-		if err := w.Broker.Enqueue(ctx, task.ID, task.Priority); err != nil {
-			log.Printf("[Worker %d] Failed to re-enqueue task %s: %v", workerID, task.ID, err)
+		// Mark it pending first so a fast re-claim is not overwritten
+		if moved, err := w.DB.TransitionTask(ctx, task.ID, models.TaskStatusRunning, models.TaskStatusPending); err != nil {
+			// Left running; the reconciler requeues it once it goes stale
+			log.Printf("[Worker %d] Failed to mark task %s pending: %v", workerID, task.ID, err)
+			return outcomeFailed
+		} else if !moved {
+			log.Printf("[Worker %d] Task %s was cancelled while running; not retrying", workerID, task.ID)
+			return outcomeFinished
+		}
+		if err := w.Broker.Schedule(ctx, task, time.Now().Add(backoffDuration)); err != nil {
+			log.Printf("[Worker %d] Failed to schedule retry of task %s: %v", workerID, task.ID, err)
+			return outcomeFailed
 		}
+		w.Broker.PublishTaskUpdate(ctx, task.ID, string(models.TaskStatusPending))
 	}
+	return outcomeFailed
 }
 
-func (w *Worker) simulateAIWork(task *models.Task) error {
-	// Simulate AI Agent call
-	time.Sleep(2 * time.Second)
+// retryPolicy returns the policy stored on task, or this node's policy for
+// its agent type if it has none.
+func (w *Worker) retryPolicy(task *models.Task) models.RetryPolicy {
+	if task.RetryPolicy != nil {
+		return *task.RetryPolicy
+	}
+	return w.RetryPolicies.For(task.AgentType)
+}
+
+// timeoutFor returns how long a single attempt of task may run.
+func (w *Worker) timeoutFor(task *models.Task) time.Duration {
+	if task.TimeoutSeconds > 0 {
+		return time.Duration(task.TimeoutSeconds) * time.Second
+	}
+	if timeout, ok := w.AgentTimeouts[task.AgentType]; ok {
+		return timeout
+	}
+	return w.DefaultTimeout
+}
 
-	// Simulate random failure for demonstration?
-	// The prompt implies "If the 'agent' fails", so I should probably make it fail sometimes?
-	// or just assumes external failure.
-	// "If the 'agent' fails, the worker should wait..."
-	// I will just return nil (success) by default, or maybe check payload for a "fail" flag for testing.
+// runHandler runs handler under a context that expires after timeout (if
+// positive). A handler that ignores its context is abandoned once the
+// context is done, so it cannot hold the worker slot indefinitely.
+func (w *Worker) runHandler(ctx context.Context, handler agents.Handler, task *models.Task, timeout time.Duration) (*agents.Result, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
-	if val, ok := task.Payload["simulate_fail"]; ok {
-		if fail, ok := val.(bool); ok && fail {
-			return fmt.Errorf("simulated AI agent error")
+	type outcome struct {
+		result *agents.Result
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- outcome{err: &panicError{value: r, stack: debug.Stack()}}
+			}
+		}()
+		result, err := handler.Handle(ctx, task)
+		done <- outcome{result, err}
+	}()
+
+	var out outcome
+	select {
+	case out = <-done:
+	case <-ctx.Done():
+		out.err = ctx.Err()
+	}
+	if out.err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("task timed out after %v: %w", timeout, context.DeadlineExceeded)
+	}
+	return out.result, out.err
+}
+
+// handBack returns a task interrupted by Drain to its queue without using
+// up a retry, unless it was cancelled in the meantime.
+func (w *Worker) handBack(ctx context.Context, workerID int, task *models.Task) taskOutcome {
+	if moved, err := w.DB.TransitionTask(ctx, task.ID, models.TaskStatusRunning, models.TaskStatusPending); err != nil {
+		// Left running; the reconciler requeues it once it goes stale
+		log.Printf("[Worker %d] Failed to mark task %s pending: %v", workerID, task.ID, err)
+		return outcomeLeft
+	} else if !moved {
+		return outcomeFinished
+	}
+	if err := w.Broker.Enqueue(ctx, task); err != nil {
+		// Pending but not queued; the reconciler requeues it
+		log.Printf("[Worker %d] Failed to re-queue task %s: %v", workerID, task.ID, err)
+		return outcomeLeft
+	}
+	log.Printf("[Worker %d] Task %s handed back to the queue", workerID, task.ID)
+	w.Broker.PublishTaskUpdate(ctx, task.ID, string(models.TaskStatusPending))
+	return outcomeHandedBack
+}
+
+// deadLetter marks a running task as permanently failed and parks it in the
+// DLQ, unless it was cancelled in the meantime.
+func (w *Worker) deadLetter(ctx context.Context, workerID int, task *models.Task, reason string) {
+	if moved, err := w.DB.FinishTask(ctx, task.ID, models.TaskPermanentFail, nil, ""); err != nil {
+		log.Printf("[Worker %d] Failed to mark %s as PERMANENT_FAILURE: %v", workerID, task.ID, err)
+	} else if !moved {
+		log.Printf("[Worker %d] Task %s was cancelled while running; not dead-lettering", workerID, task.ID)
+		return
+	}
+	if err := w.Broker.AddToDLQ(ctx, task.ID, reason); err != nil {
+		log.Printf("[Worker %d] Failed to add %s to DLQ: %v", workerID, task.ID, err)
+	}
+	w.Broker.PublishTaskUpdate(ctx, task.ID, string(models.TaskPermanentFail))
+}
+
+// renewLease renews the lease on taskID every third of the lease timeout until
+// ctx is cancelled. If the lease is lost the task may already be running
+// elsewhere, which is logged but otherwise tolerated (at-least-once).
+func (w *Worker) renewLease(ctx context.Context, workerID int, taskID string) {
+	ticker := time.NewTicker(w.Broker.LeaseTimeout() / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := w.Broker.RenewLease(ctx, w.ID, taskID)
+			if errors.Is(err, broker.ErrLeaseLost) {
+				log.Printf("[Worker %d] Lease on task %s expired; it may be picked up by another worker", workerID, taskID)
+				return
+			}
+			if err != nil && ctx.Err() == nil {
+				log.Printf("[Worker %d] Failed to renew lease on task %s: %v", workerID, taskID, err)
+			}
 		}
 	}
+}
 
-	return nil
+// panicError is returned by runHandler when the handler panics.
+type panicError struct {
+	value any
+	stack []byte
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// errorClass sorts a failure into one of the models.ErrorClass* buckets for
+// the attempt history.
+func errorClass(err error) string {
+	var agentErr *agents.Error
+	var panicked *panicError
+	switch {
+	case errors.As(err, &panicked):
+		return models.ErrorClassPanic
+	case errors.As(err, &agentErr):
+		return agentErr.Class()
+	case errors.Is(err, context.DeadlineExceeded):
+		return models.ErrorClassTimeout
+	case errors.Is(err, context.Canceled):
+		return models.ErrorClassCancelled
+	default:
+		return models.ErrorClassError
+	}
 }
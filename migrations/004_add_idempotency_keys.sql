@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS task_idempotency_keys (
+    idempotency_key VARCHAR(255) PRIMARY KEY,
+    task_id UUID NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,5 @@
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS result JSONB;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS result_text TEXT;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS worker_id VARCHAR(255);
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS started_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS finished_at TIMESTAMP WITH TIME ZONE;
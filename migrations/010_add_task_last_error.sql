@@ -0,0 +1,2 @@
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS last_error TEXT;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS last_error_class VARCHAR(50);
@@ -0,0 +1,125 @@
+package broker
+
+import (
+	"math/rand"
+	"strings"
+)
+
+// Priority bands. Tasks are ordered by exact (aged) priority within a band;
+// the dequeue mode decides how workers choose between bands.
+const (
+	BandHigh   = "high"
+	BandMedium = "medium"
+	BandLow    = "low"
+)
+
+// Bands lists every band from highest to lowest.
+var Bands = []string{BandHigh, BandMedium, BandLow}
+
+// Dequeue modes.
+const (
+	// DequeueStrict always serves the highest effective priority first,
+	// regardless of band.
+	DequeueStrict = "strict"
+	// DequeueWeighted picks a band at random in proportion to its weight on
+	// every fetch, so lower bands keep making progress under load.
+	DequeueWeighted = "weighted"
+)
+
+// DefaultBandWeights is the high:medium:low draw ratio used by
+// DequeueWeighted when none is configured.
+var DefaultBandWeights = map[string]int{BandHigh: 6, BandMedium: 3, BandLow: 1}
+
+func bandForPriority(priority int) string {
+	if priority >= 3 {
+		return BandHigh
+	} else if priority == 2 {
+		return BandMedium
+	}
+	return BandLow
+}
+
+// bandOfQueue extracts the band a queue key belongs to; queue keys end in
+// ":<agent type>:<band>".
+func bandOfQueue(queue string) string {
+	return queue[strings.LastIndexByte(queue, ':')+1:]
+}
+
+// bandPicker decides in which order a fetch should try the queues it serves.
+type bandPicker struct {
+	mode    string
+	weights map[string]int
+}
+
+func newBandPicker(mode string, weights map[string]int) bandPicker {
+	if len(weights) == 0 {
+		weights = DefaultBandWeights
+	}
+	return bandPicker{mode: mode, weights: weights}
+}
+
+// order groups the indices of bands (one entry per queue) into the sequence
+// of groups to try. Within a group the highest effective priority wins; a
+// later group is only tried when every earlier one is empty.
+//
+// In strict mode there is a single group holding every queue. In weighted
+// mode one band is drawn by weight and tried first, followed by the others
+// from highest to lowest.
+func (p bandPicker) order(bands []string) [][]int {
+	all := make([]int, len(bands))
+	for i := range bands {
+		all[i] = i
+	}
+	if p.mode != DequeueWeighted {
+		return [][]int{all}
+	}
+
+	first := p.draw(bands)
+	sequence := []string{first}
+	for _, band := range Bands {
+		if band != first {
+			sequence = append(sequence, band)
+		}
+	}
+
+	var groups [][]int
+	for _, band := range sequence {
+		var group []int
+		for i, b := range bands {
+			if b == band {
+				group = append(group, i)
+			}
+		}
+		if len(group) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// draw picks one of the bands present in bands in proportion to its weight.
+func (p bandPicker) draw(bands []string) string {
+	present := make(map[string]bool, len(bands))
+	total := 0
+	for _, band := range bands {
+		if !present[band] {
+			present[band] = true
+			total += p.weights[band]
+		}
+	}
+	if total <= 0 {
+		return BandHigh
+	}
+
+	n := rand.Intn(total)
+	for _, band := range Bands {
+		if !present[band] {
+			continue
+		}
+		if n < p.weights[band] {
+			return band
+		}
+		n -= p.weights[band]
+	}
+	return BandHigh
+}
@@ -0,0 +1,99 @@
+package broker
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBandForPriority(t *testing.T) {
+	tests := []struct {
+		priority int
+		band     string
+	}{
+		{5, BandHigh},
+		{3, BandHigh},
+		{2, BandMedium},
+		{1, BandLow},
+		{0, BandLow},
+	}
+	for _, tt := range tests {
+		if got := bandForPriority(tt.priority); got != tt.band {
+			t.Errorf("bandForPriority(%d) = %s, want %s", tt.priority, got, tt.band)
+		}
+	}
+}
+
+func TestWeightedDrawFollowsWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[string]int
+		bands   []string
+		want    map[string]float64
+	}{
+		{
+			name:    "default weights",
+			weights: DefaultBandWeights,
+			bands:   Bands,
+			want:    map[string]float64{BandHigh: 0.6, BandMedium: 0.3, BandLow: 0.1},
+		},
+		{
+			name:    "only present bands are drawn",
+			weights: DefaultBandWeights,
+			bands:   []string{BandMedium, BandLow, BandLow},
+			want:    map[string]float64{BandMedium: 0.75, BandLow: 0.25},
+		},
+		{
+			name:    "even weights",
+			weights: map[string]int{BandHigh: 1, BandMedium: 1, BandLow: 1},
+			bands:   Bands,
+			want:    map[string]float64{BandHigh: 1.0 / 3, BandMedium: 1.0 / 3, BandLow: 1.0 / 3},
+		},
+	}
+
+	const draws = 20000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			picker := newBandPicker(DequeueWeighted, tt.weights)
+			counts := make(map[string]int)
+			for i := 0; i < draws; i++ {
+				counts[picker.draw(tt.bands)]++
+			}
+			for band, share := range tt.want {
+				got := float64(counts[band]) / draws
+				if math.Abs(got-share) > 0.03 {
+					t.Errorf("band %s drawn %.3f of the time, want %.3f", band, got, share)
+				}
+			}
+			for band, n := range counts {
+				if _, ok := tt.want[band]; !ok {
+					t.Errorf("band %s drawn %d times, want never", band, n)
+				}
+			}
+		})
+	}
+}
+
+func TestOrderGroupsQueuesByBand(t *testing.T) {
+	bands := []string{BandLow, BandHigh, BandMedium, BandHigh}
+
+	strict := newBandPicker(DequeueStrict, nil).order(bands)
+	if len(strict) != 1 || len(strict[0]) != len(bands) {
+		t.Fatalf("strict order = %v, want one group with every queue", strict)
+	}
+
+	weighted := newBandPicker(DequeueWeighted, map[string]int{BandHigh: 0, BandMedium: 0, BandLow: 1}).order(bands)
+	want := [][]int{{0}, {1, 3}, {2}}
+	if len(weighted) != len(want) {
+		t.Fatalf("weighted order = %v, want %v", weighted, want)
+	}
+	for i := range want {
+		if len(weighted[i]) != len(want[i]) {
+			t.Fatalf("weighted order = %v, want %v", weighted, want)
+		}
+		for j := range want[i] {
+			if weighted[i][j] != want[i][j] {
+				t.Fatalf("weighted order = %v, want %v", weighted, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/config"
+	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+	"github.com/YehiaGewily/Agent-Mesh/pkg/database"
+)
+
+// Supported values for config.Config.BrokerBackend.
+const (
+	BackendLists   = "lists"
+	BackendStreams = "streams"
+	// BackendMemory keeps everything in process memory. It only works when
+	// the producer and its workers run in the same process.
+	BackendMemory = "memory"
+)
+
+// Broker moves task IDs from producers to workers and fans out task and
+// health events to subscribers.
+type Broker interface {
+	// Enqueue makes a task visible to workers serving its agent type,
+	// according to its priority.
+	Enqueue(ctx context.Context, task *models.Task) error
+	// FetchTask blocks until a task for one of agentTypes (any type if none
+	// are given) is available, claims it for consumer and returns its ID.
+	// The task stays leased to consumer until Ack.
+	FetchTask(ctx context.Context, consumer string, agentTypes ...string) (string, error)
+	// Ack releases a fetched task once the consumer is done with it.
+	Ack(ctx context.Context, consumer, taskID string) error
+	// RenewLease keeps a fetched task invisible to other consumers for
+	// another LeaseTimeout. It returns ErrLeaseLost if consumer no longer
+	// holds the task.
+	RenewLease(ctx context.Context, consumer, taskID string) error
+	// LeaseTimeout reports how long a fetched task stays claimed without
+	// renewal.
+	LeaseTimeout() time.Duration
+	// Heartbeat marks consumer as alive for ttl.
+	Heartbeat(ctx context.Context, consumer string, ttl time.Duration) error
+	// RegisterWorker adds or refreshes a node in the worker registry. The
+	// entry expires unless it is registered again within ttl.
+	RegisterWorker(ctx context.Context, info *models.WorkerInfo, ttl time.Duration) error
+	// DeregisterWorker removes a node from the worker registry.
+	DeregisterWorker(ctx context.Context, id string) error
+	// ListWorkers returns the live nodes in the worker registry, by ID.
+	ListWorkers(ctx context.Context) ([]models.WorkerInfo, error)
+	// ReapOrphans makes tasks held by dead consumers or expired leases
+	// visible again and reports how many were requeued.
+	ReapOrphans(ctx context.Context) (int, error)
+	// Schedule makes a task visible to workers once readyAt has passed, as
+	// if it were enqueued at that time.
+	Schedule(ctx context.Context, task *models.Task, readyAt time.Time) error
+	// PromoteDue enqueues every scheduled task whose time has come and
+	// reports how many were promoted.
+	PromoteDue(ctx context.Context) (int, error)
+	// AddToDLQ parks a task that will not be retried, recording why. Adding
+	// a task that is already parked replaces its entry.
+	AddToDLQ(ctx context.Context, taskID, reason string) error
+	// ListDLQ returns every parked task, oldest first.
+	ListDLQ(ctx context.Context) ([]DLQEntry, error)
+	// RemoveFromDLQ drops a task from the dead-letter queue and reports
+	// whether it was there.
+	RemoveFromDLQ(ctx context.Context, taskID string) (bool, error)
+	// PurgeDLQ drops every parked task and returns how many there were.
+	PurgeDLQ(ctx context.Context) (int, error)
+	// QueueDepth reports how many tasks of agentTypes (every type if none
+	// are given) are ready and waiting to be fetched.
+	QueueDepth(ctx context.Context, agentTypes ...string) (int, error)
+	// Remove takes a task that has not been fetched yet off its queue or out
+	// of the delayed set. Removing a task the broker does not hold is not an
+	// error.
+	Remove(ctx context.Context, task *models.Task) error
+	// Tracked reports, by task ID, which of tasks the broker currently holds
+	// anywhere: ready, in flight, scheduled or dead-lettered.
+	Tracked(ctx context.Context, tasks []*models.Task) (map[string]bool, error)
+
+	PublishTaskUpdate(ctx context.Context, taskID, status string) error
+	PublishTaskEvent(ctx context.Context, task *models.Task) error
+	PublishSystemHealth(ctx context.Context, health *models.SystemHealth) error
+	// PublishScalingEvent announces a worker node changing its concurrency.
+	PublishScalingEvent(ctx context.Context, event *models.ScalingEvent) error
+	// PublishCancellation asks whichever worker is running taskID to stop.
+	PublishCancellation(ctx context.Context, taskID string) error
+	SubscribeSystemHealth(ctx context.Context) Subscription
+	SubscribeTaskUpdates(ctx context.Context) Subscription
+	SubscribeScalingEvents(ctx context.Context) Subscription
+	SubscribeCancellations(ctx context.Context) Subscription
+}
+
+// TaskStore is the part of the task database the brokers need to claim,
+// requeue and dead-letter tasks. *database.DB implements it.
+type TaskStore interface {
+	GetTask(ctx context.Context, taskID string) (*models.Task, error)
+	// ClaimTask marks a fetched task as running, reporting false if it must
+	// not run again.
+	ClaimTask(ctx context.Context, taskID string) (bool, error)
+	// TransitionTask moves a task from one status to another, reporting
+	// false if it was not in the from status.
+	TransitionTask(ctx context.Context, taskID string, from, to models.TaskStatus) (bool, error)
+	// FinishTask moves a running task to a final status, reporting false if
+	// it was no longer running.
+	FinishTask(ctx context.Context, taskID string, status models.TaskStatus, result map[string]interface{}, resultText string) (bool, error)
+}
+
+var (
+	_ Broker = (*RedisBroker)(nil)
+	_ Broker = (*StreamBroker)(nil)
+	_ Broker = (*MemoryBroker)(nil)
+
+	_ TaskStore = (*database.DB)(nil)
+)
+
+// Options tunes the behaviour shared by all broker backends.
+type Options struct {
+	// LeaseTimeout is how long a fetched task stays claimed without renewal.
+	LeaseTimeout time.Duration
+	// AgingRate is how many priority levels a waiting task gains per minute,
+	// so low priority work cannot starve. Zero means strict priority order.
+	AgingRate float64
+	// DequeueMode is DequeueStrict (default) or DequeueWeighted.
+	DequeueMode string
+	// BandWeights are the per-band draw weights used by DequeueWeighted.
+	BandWeights map[string]int
+}
+
+func (o Options) withDefaults() Options {
+	if o.LeaseTimeout <= 0 {
+		o.LeaseTimeout = DefaultLeaseTimeout
+	}
+	if o.AgingRate < 0 {
+		o.AgingRate = 0
+	}
+	if o.DequeueMode == "" {
+		o.DequeueMode = DequeueStrict
+	}
+	return o
+}
+
+// New builds the broker backend selected in cfg.
+func New(cfg *config.Config, db *database.DB) (Broker, error) {
+	opts := Options{
+		LeaseTimeout: cfg.LeaseTimeout,
+		AgingRate:    cfg.PriorityAgingRate,
+		DequeueMode:  cfg.DequeueMode,
+	}
+	switch cfg.DequeueMode {
+	case DequeueStrict, DequeueWeighted, "":
+	default:
+		return nil, fmt.Errorf("unknown dequeue mode %q", cfg.DequeueMode)
+	}
+	if len(cfg.DequeueWeights) > 0 {
+		if len(cfg.DequeueWeights) != len(Bands) {
+			return nil, fmt.Errorf("dequeue weights must have one entry per band (%s), got %v",
+				strings.Join(Bands, ":"), cfg.DequeueWeights)
+		}
+		opts.BandWeights = make(map[string]int, len(Bands))
+		for i, band := range Bands {
+			if cfg.DequeueWeights[i] <= 0 {
+				return nil, fmt.Errorf("dequeue weights must be positive, got %v", cfg.DequeueWeights)
+			}
+			opts.BandWeights[band] = cfg.DequeueWeights[i]
+		}
+	}
+
+	switch cfg.BrokerBackend {
+	case BackendLists, "":
+		return NewBroker(cfg.RedisAddr, db, opts), nil
+	case BackendStreams:
+		return NewStreamBroker(cfg.RedisAddr, db, opts), nil
+	case BackendMemory:
+		return NewMemoryBroker(db, opts), nil
+	default:
+		return nil, fmt.Errorf("unknown broker backend %q", cfg.BrokerBackend)
+	}
+}
+
+// errTaskFinished is returned by claim for tasks that must not run again,
+// e.g. because they were cancelled while queued. Brokers drop such tasks
+// and keep fetching.
+var errTaskFinished = errors.New("task already finished")
+
+// claim marks a freshly fetched task as running in Postgres and notifies
+// subscribers (Claim pattern).
+func claim(ctx context.Context, db TaskStore, b Broker, taskID string) error {
+	claimed, err := db.ClaimTask(ctx, taskID)
+	if err != nil {
+		return fmt.Errorf("failed to claim task %s: %w", taskID, err)
+	}
+	if !claimed {
+		return errTaskFinished
+	}
+
+	// Notify Real-Time (Running)
+	b.PublishTaskUpdate(ctx, taskID, string(models.TaskStatusRunning))
+	return nil
+}
+
+// delayedMember encodes a scheduled task so the promoter knows which queue
+// to enqueue it on without a database round trip.
+func delayedMember(task *models.Task) string {
+	return fmt.Sprintf("%d:%s:%s", task.Priority, task.AgentType, task.ID)
+}
+
+// parseDelayedMember decodes a delayedMember into a task carrying just the
+// fields needed to route it.
+func parseDelayedMember(member string) (*models.Task, error) {
+	parts := strings.SplitN(member, ":", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed delayed entry %q", member)
+	}
+	priority, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed delayed entry %q: %w", member, err)
+	}
+	return &models.Task{ID: parts[2], AgentType: parts[1], Priority: priority}, nil
+}
+
+// fetchPollInterval is how long FetchTask waits before looking for work
+// again when every queue it serves is empty.
+const fetchPollInterval = 250 * time.Millisecond
+
+// agingEpoch is subtracted from enqueue times before scoring so scores stay
+// small enough for float64 to keep millisecond resolution.
+var agingEpoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fifoWeight orders tasks of equal effective priority by enqueue time. It is
+// small enough that it never outweighs a whole priority level.
+const fifoWeight = 1e-9
+
+// readyScore orders waiting tasks so that the lowest score has the highest
+// effective priority, where
+//
+//	effective = priority + agingRate/min * (now - enqueuedAt)
+//
+// Since "now" is the same for every task when comparing, ranking by
+// agingRate*enqueuedAt - priority is equivalent and can be computed once at
+// enqueue time.
+func readyScore(priority int, enqueuedAt time.Time, agingRate float64) float64 {
+	waited := enqueuedAt.Sub(agingEpoch).Seconds()
+	return (agingRate/60+fifoWeight)*waited - float64(priority)
+}
+
+// sleepCtx waits for d or until ctx is done, whichever comes first.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,126 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+	"github.com/YehiaGewily/Agent-Mesh/pkg/database"
+)
+
+// fakeStore is an in-memory TaskStore with the same status rules as
+// database.DB.
+type fakeStore struct {
+	mu    sync.Mutex
+	tasks map[string]*models.Task
+	// claimErr is returned by the next ClaimTask call.
+	claimErr error
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{tasks: make(map[string]*models.Task)}
+}
+
+// add stores a pending task and returns it.
+func (s *fakeStore) add(id, agentType string, priority int) *models.Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task := &models.Task{
+		ID:        id,
+		AgentType: agentType,
+		Priority:  priority,
+		Status:    models.TaskStatusPending,
+		CreatedAt: time.Now(),
+	}
+	s.tasks[id] = task
+	copied := *task
+	return &copied
+}
+
+func (s *fakeStore) setStatus(id string, status models.TaskStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks[id].Status = status
+}
+
+func (s *fakeStore) status(id string) models.TaskStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tasks[id].Status
+}
+
+func (s *fakeStore) GetTask(ctx context.Context, taskID string) (*models.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, ok := s.tasks[taskID]
+	if !ok {
+		return nil, database.ErrTaskNotFound
+	}
+	copied := *task
+	return &copied, nil
+}
+
+func (s *fakeStore) ClaimTask(ctx context.Context, taskID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.claimErr; err != nil {
+		s.claimErr = nil
+		return false, err
+	}
+	task, ok := s.tasks[taskID]
+	if !ok {
+		return false, nil
+	}
+	switch task.Status {
+	case models.TaskStatusCompleted, models.TaskPermanentFail, models.TaskStatusCancelled:
+		return false, nil
+	}
+	task.Status = models.TaskStatusRunning
+	return true, nil
+}
+
+func (s *fakeStore) TransitionTask(ctx context.Context, taskID string, from, to models.TaskStatus) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, ok := s.tasks[taskID]
+	if !ok || task.Status != from {
+		return false, nil
+	}
+	task.Status = to
+	return true, nil
+}
+
+func (s *fakeStore) FinishTask(ctx context.Context, taskID string, status models.TaskStatus, result map[string]interface{}, resultText string) (bool, error) {
+	return s.TransitionTask(ctx, taskID, models.TaskStatusRunning, status)
+}
+
+func TestReadyScoreAging(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		agingRate float64
+		// older waited since base, newer arrived later with a higher
+		// priority.
+		olderPriority, newerPriority int
+		newerAfter                   time.Duration
+		olderFirst                   bool
+	}{
+		{"strict priority wins without aging", 0, 1, 3, time.Hour, false},
+		{"equal priority is FIFO", 0, 2, 2, time.Second, true},
+		{"aging not yet enough", 1, 1, 3, time.Minute, false},
+		{"aging overtakes higher priority", 1, 1, 3, 3 * time.Minute, true},
+		{"slow aging", 0.1, 1, 3, 10 * time.Minute, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			older := readyScore(tt.olderPriority, base, tt.agingRate)
+			newer := readyScore(tt.newerPriority, base.Add(tt.newerAfter), tt.agingRate)
+			if got := older < newer; got != tt.olderFirst {
+				t.Errorf("older first = %v, want %v (scores %v vs %v)", got, tt.olderFirst, older, newer)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package broker
+
+import (
+	"sort"
+	"time"
+)
+
+// DLQEntry is a task parked in the dead-letter queue.
+type DLQEntry struct {
+	TaskID   string    `json:"task_id"`
+	Reason   string    `json:"reason"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// sortDLQ orders entries oldest first.
+func sortDLQ(entries []DLQEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].FailedAt.Before(entries[j].FailedAt)
+	})
+}
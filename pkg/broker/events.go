@@ -0,0 +1,147 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// Pub/sub channels shared by every broker implementation.
+const (
+	ChannelTaskUpdates  = "task_updates"
+	ChannelSystemHealth = "system_health"
+	// ChannelTaskCancellations carries the IDs of running tasks that should
+	// be stopped.
+	ChannelTaskCancellations = "task_cancellations"
+	// ChannelScalingEvents carries worker concurrency changes.
+	ChannelScalingEvents = "scaling_events"
+)
+
+// Subscription delivers the raw payloads published on a broker channel until
+// it is closed.
+type Subscription interface {
+	Messages() <-chan string
+	Close() error
+}
+
+// redisEvents implements the pub/sub half of the Broker interface, and the
+// worker registry. It is shared by every Redis-backed broker so they all
+// publish on the same channels.
+type redisEvents struct {
+	Client *redis.Client
+}
+
+func (e redisEvents) PublishTaskUpdate(ctx context.Context, taskID, status string) error {
+	err := e.Client.Publish(ctx, ChannelTaskUpdates, taskUpdateMessage(taskID, status)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to publish update: %w", err)
+	}
+	return nil
+}
+
+func (e redisEvents) PublishTaskEvent(ctx context.Context, task *models.Task) error {
+	data, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("failed to marshal task: %w", err)
+	}
+
+	err = e.Client.Publish(ctx, ChannelTaskUpdates, data).Err()
+	if err != nil {
+		return fmt.Errorf("failed to publish task event: %w", err)
+	}
+	return nil
+}
+
+func (e redisEvents) PublishSystemHealth(ctx context.Context, health *models.SystemHealth) error {
+	data, err := json.Marshal(health)
+	if err != nil {
+		return fmt.Errorf("failed to marshal health metric: %w", err)
+	}
+
+	err = e.Client.Publish(ctx, ChannelSystemHealth, data).Err()
+	if err != nil {
+		return fmt.Errorf("failed to publish health metric: %w", err)
+	}
+	return nil
+}
+
+func (e redisEvents) PublishScalingEvent(ctx context.Context, event *models.ScalingEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal scaling event: %w", err)
+	}
+
+	err = e.Client.Publish(ctx, ChannelScalingEvents, data).Err()
+	if err != nil {
+		return fmt.Errorf("failed to publish scaling event: %w", err)
+	}
+	return nil
+}
+
+func (e redisEvents) PublishCancellation(ctx context.Context, taskID string) error {
+	err := e.Client.Publish(ctx, ChannelTaskCancellations, taskID).Err()
+	if err != nil {
+		return fmt.Errorf("failed to publish cancellation: %w", err)
+	}
+	return nil
+}
+
+func (e redisEvents) SubscribeSystemHealth(ctx context.Context) Subscription {
+	return newRedisSubscription(e.Client.Subscribe(ctx, ChannelSystemHealth))
+}
+
+func (e redisEvents) SubscribeTaskUpdates(ctx context.Context) Subscription {
+	return newRedisSubscription(e.Client.Subscribe(ctx, ChannelTaskUpdates))
+}
+
+func (e redisEvents) SubscribeScalingEvents(ctx context.Context) Subscription {
+	return newRedisSubscription(e.Client.Subscribe(ctx, ChannelScalingEvents))
+}
+
+func (e redisEvents) SubscribeCancellations(ctx context.Context) Subscription {
+	return newRedisSubscription(e.Client.Subscribe(ctx, ChannelTaskCancellations))
+}
+
+func taskUpdateMessage(taskID, status string) string {
+	return fmt.Sprintf(`{"task_id":"%s","status":"%s"}`, taskID, status)
+}
+
+// redisSubscription adapts a *redis.PubSub to Subscription.
+type redisSubscription struct {
+	pubsub   *redis.PubSub
+	messages chan string
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newRedisSubscription(pubsub *redis.PubSub) *redisSubscription {
+	s := &redisSubscription{
+		pubsub:   pubsub,
+		messages: make(chan string),
+		done:     make(chan struct{}),
+	}
+	go func() {
+		defer close(s.messages)
+		for msg := range pubsub.Channel() {
+			select {
+			case s.messages <- msg.Payload:
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *redisSubscription) Messages() <-chan string {
+	return s.messages
+}
+
+func (s *redisSubscription) Close() error {
+	s.once.Do(func() { close(s.done) })
+	return s.pubsub.Close()
+}
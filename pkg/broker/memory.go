@@ -0,0 +1,606 @@
+package broker
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+)
+
+// memorySubscriptionBuffer is how many messages a slow in-memory subscriber
+// may fall behind before further messages are dropped, mirroring Redis
+// pub/sub's fire-and-forget delivery.
+const memorySubscriptionBuffer = 256
+
+// MemoryBroker is a Broker that keeps queues, leases and pub/sub channels in
+// process memory. It follows the same priority semantics as RedisBroker and
+// is meant for tests and single-process demos: nothing survives a restart
+// and producers and workers must share the same instance.
+type MemoryBroker struct {
+	DB TaskStore
+
+	leaseTimeout time.Duration
+	agingRate    float64
+	picker       bandPicker
+
+	mu         sync.Mutex
+	queues     map[string]*readyHeap
+	queued     map[string]string // taskID → ready queue holding it
+	seq        uint64
+	inflight   map[string]memoryLease // taskID → lease
+	heartbeats map[string]time.Time   // consumer → liveness expiry
+	workers    map[string]memoryWorker
+	delayed    []memoryDelayed
+	deadLetter []DLQEntry
+	// ready is closed and replaced whenever a task is enqueued so blocked
+	// fetchers wake up.
+	ready chan struct{}
+
+	subMu       sync.Mutex
+	subscribers map[string]map[*memorySubscription]struct{}
+}
+
+type memoryLease struct {
+	consumer string
+	queue    string
+	entry    readyEntry
+	expiry   time.Time
+}
+
+type memoryWorker struct {
+	info   models.WorkerInfo
+	expiry time.Time
+}
+
+type memoryDelayed struct {
+	taskID    string
+	agentType string
+	priority  int
+	readyAt   time.Time
+}
+
+func NewMemoryBroker(db TaskStore, opts Options) *MemoryBroker {
+	opts = opts.withDefaults()
+	return &MemoryBroker{
+		DB:           db,
+		leaseTimeout: opts.LeaseTimeout,
+		agingRate:    opts.AgingRate,
+		picker:       newBandPicker(opts.DequeueMode, opts.BandWeights),
+		queues:       make(map[string]*readyHeap),
+		queued:       make(map[string]string),
+		inflight:     make(map[string]memoryLease),
+		heartbeats:   make(map[string]time.Time),
+		workers:      make(map[string]memoryWorker),
+		ready:        make(chan struct{}),
+		subscribers:  make(map[string]map[*memorySubscription]struct{}),
+	}
+}
+
+func (b *MemoryBroker) LeaseTimeout() time.Duration {
+	return b.leaseTimeout
+}
+
+// Enqueue queues a task, replacing its entry if it is already queued, like
+// ZADD does for RedisBroker. A task that is still in flight is queued all
+// the same, since handing it back or retrying it enqueues it before the
+// worker acks.
+func (b *MemoryBroker) Enqueue(ctx context.Context, task *models.Task) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	queue := readyQueue(task.AgentType, task.Priority)
+	b.pushLocked(queue, task.ID, readyScore(task.Priority, time.Now(), b.agingRate))
+	return nil
+}
+
+func (b *MemoryBroker) Schedule(ctx context.Context, task *models.Task, readyAt time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.delayed = append(b.delayed, memoryDelayed{
+		taskID:    task.ID,
+		agentType: task.AgentType,
+		priority:  task.Priority,
+		readyAt:   readyAt,
+	})
+	return nil
+}
+
+func (b *MemoryBroker) PromoteDue(ctx context.Context) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	promoted := 0
+	waiting := b.delayed[:0]
+	for _, d := range b.delayed {
+		if d.readyAt.After(now) {
+			waiting = append(waiting, d)
+			continue
+		}
+		b.pushLocked(readyQueue(d.agentType, d.priority), d.taskID, readyScore(d.priority, now, b.agingRate))
+		promoted++
+	}
+	b.delayed = waiting
+	return promoted, nil
+}
+
+// FetchTask pops the next task across the ready queues of the given agent
+// types (every type by default), as chosen by the dequeue mode, and leases it
+// to consumer. It blocks until a task is available or ctx is done.
+func (b *MemoryBroker) FetchTask(ctx context.Context, consumer string, agentTypes ...string) (string, error) {
+	for {
+		b.mu.Lock()
+		queues := readyQueues(agentTypes)
+		if len(agentTypes) == 0 {
+			queues = b.queueNamesLocked()
+		}
+		taskID, ok := b.popLocked(consumer, queues)
+		ready := b.ready
+		b.mu.Unlock()
+
+		if ok {
+			if err := claim(ctx, b.DB, b, taskID); err != nil {
+				b.mu.Lock()
+				if errors.Is(err, errTaskFinished) {
+					delete(b.inflight, taskID)
+					b.mu.Unlock()
+					continue
+				}
+				b.requeueLocked(taskID)
+				b.mu.Unlock()
+				return "", err
+			}
+			return taskID, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("failed to fetch task: %w", ctx.Err())
+		case <-ready:
+		case <-time.After(fetchPollInterval):
+		}
+	}
+}
+
+func (b *MemoryBroker) popLocked(consumer string, queues []string) (string, bool) {
+	bands := make([]string, len(queues))
+	for i, queue := range queues {
+		bands[i] = bandOfQueue(queue)
+	}
+
+	best := ""
+	for _, group := range b.picker.order(bands) {
+		for _, i := range group {
+			h := b.queues[queues[i]]
+			if h == nil || h.Len() == 0 {
+				continue
+			}
+			if best == "" || h.less((*h)[0], (*b.queues[best])[0]) {
+				best = queues[i]
+			}
+		}
+		if best != "" {
+			break
+		}
+	}
+	if best == "" {
+		return "", false
+	}
+
+	entry := heap.Pop(b.queues[best]).(readyEntry)
+	delete(b.queued, entry.taskID)
+	b.inflight[entry.taskID] = memoryLease{
+		consumer: consumer,
+		queue:    best,
+		entry:    entry,
+		expiry:   time.Now().Add(b.leaseTimeout),
+	}
+	return entry.taskID, true
+}
+
+// queueNamesLocked returns every ready queue that has been used so far.
+func (b *MemoryBroker) queueNamesLocked() []string {
+	queues := make([]string, 0, len(b.queues))
+	for queue := range b.queues {
+		queues = append(queues, queue)
+	}
+	return queues
+}
+
+func (b *MemoryBroker) pushLocked(queue, taskID string, score float64) {
+	b.seq++
+	b.pushEntryLocked(queue, readyEntry{taskID: taskID, score: score, seq: b.seq})
+}
+
+// pushEntryLocked queues entry, dropping any entry the task already has so
+// it is never queued twice.
+func (b *MemoryBroker) pushEntryLocked(queue string, entry readyEntry) {
+	b.unqueueLocked(entry.taskID)
+	h := b.queues[queue]
+	if h == nil {
+		h = &readyHeap{}
+		b.queues[queue] = h
+	}
+	heap.Push(h, entry)
+	b.queued[entry.taskID] = queue
+	b.wakeLocked()
+}
+
+// unqueueLocked removes a task from the ready queue holding it, if any.
+func (b *MemoryBroker) unqueueLocked(taskID string) {
+	queue, ok := b.queued[taskID]
+	if !ok {
+		return
+	}
+	delete(b.queued, taskID)
+	h := b.queues[queue]
+	for i, entry := range *h {
+		if entry.taskID == taskID {
+			heap.Remove(h, i)
+			return
+		}
+	}
+}
+
+func (b *MemoryBroker) Ack(ctx context.Context, consumer, taskID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if lease, ok := b.inflight[taskID]; ok && lease.consumer == consumer {
+		delete(b.inflight, taskID)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) RenewLease(ctx context.Context, consumer, taskID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lease, ok := b.inflight[taskID]
+	if !ok || lease.consumer != consumer {
+		return fmt.Errorf("task %s: %w", taskID, ErrLeaseLost)
+	}
+	lease.expiry = time.Now().Add(b.leaseTimeout)
+	b.inflight[taskID] = lease
+	return nil
+}
+
+func (b *MemoryBroker) Heartbeat(ctx context.Context, consumer string, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.heartbeats[consumer] = time.Now().Add(ttl)
+	return nil
+}
+
+func (b *MemoryBroker) RegisterWorker(ctx context.Context, info *models.WorkerInfo, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.workers[info.ID] = memoryWorker{info: *info, expiry: time.Now().Add(ttl)}
+	return nil
+}
+
+func (b *MemoryBroker) DeregisterWorker(ctx context.Context, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.workers, id)
+	return nil
+}
+
+func (b *MemoryBroker) ListWorkers(ctx context.Context) ([]models.WorkerInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	workers := []models.WorkerInfo{}
+	for id, worker := range b.workers {
+		if !worker.expiry.After(now) {
+			delete(b.workers, id)
+			continue
+		}
+		workers = append(workers, worker.info)
+	}
+	sortWorkers(workers)
+	return workers, nil
+}
+
+// ReapOrphans marks tasks whose lease expired, or whose consumer stopped
+// sending heartbeats, pending again and puts them back into their queue with
+// their original score. Tasks that are no longer running (cancelled or
+// finished meanwhile) are dropped instead.
+func (b *MemoryBroker) ReapOrphans(ctx context.Context) (int, error) {
+	now := time.Now()
+
+	b.mu.Lock()
+	orphans := make(map[string]memoryLease)
+	for taskID, lease := range b.inflight {
+		alive, seen := b.heartbeats[lease.consumer]
+		if lease.expiry.After(now) && (!seen || alive.After(now)) {
+			continue
+		}
+		delete(b.inflight, taskID)
+		orphans[taskID] = lease
+	}
+	b.mu.Unlock()
+
+	reaped := 0
+	for taskID, lease := range orphans {
+		reset, err := b.DB.TransitionTask(ctx, taskID, models.TaskStatusRunning, models.TaskStatusPending)
+		if err != nil {
+			// Keep the expired lease so the next pass tries again
+			b.mu.Lock()
+			b.inflight[taskID] = lease
+			b.mu.Unlock()
+			return reaped, fmt.Errorf("failed to reset task %s to pending: %w", taskID, err)
+		}
+		if !reset {
+			continue
+		}
+
+		b.mu.Lock()
+		b.pushEntryLocked(lease.queue, lease.entry)
+		b.mu.Unlock()
+		reaped++
+		b.PublishTaskUpdate(ctx, taskID, string(models.TaskStatusPending))
+	}
+	return reaped, nil
+}
+
+// requeueLocked moves an in-flight task back to the queue it was fetched
+// from, keeping its original place in line.
+func (b *MemoryBroker) requeueLocked(taskID string) {
+	lease, ok := b.inflight[taskID]
+	if !ok {
+		return
+	}
+	delete(b.inflight, taskID)
+	b.pushEntryLocked(lease.queue, lease.entry)
+}
+
+func (b *MemoryBroker) wakeLocked() {
+	close(b.ready)
+	b.ready = make(chan struct{})
+}
+
+func (b *MemoryBroker) QueueDepth(ctx context.Context, agentTypes ...string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	queues := readyQueues(agentTypes)
+	if len(agentTypes) == 0 {
+		queues = b.queueNamesLocked()
+	}
+	depth := 0
+	for _, queue := range queues {
+		if h, ok := b.queues[queue]; ok {
+			depth += h.Len()
+		}
+	}
+	return depth, nil
+}
+
+func (b *MemoryBroker) Remove(ctx context.Context, task *models.Task) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.unqueueLocked(task.ID)
+	waiting := b.delayed[:0]
+	for _, d := range b.delayed {
+		if d.taskID != task.ID {
+			waiting = append(waiting, d)
+		}
+	}
+	b.delayed = waiting
+	return nil
+}
+
+func (b *MemoryBroker) Tracked(ctx context.Context, tasks []*models.Task) (map[string]bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	known := make(map[string]bool)
+	for _, h := range b.queues {
+		for _, entry := range *h {
+			known[entry.taskID] = true
+		}
+	}
+	for taskID := range b.inflight {
+		known[taskID] = true
+	}
+	for _, d := range b.delayed {
+		known[d.taskID] = true
+	}
+	for _, entry := range b.deadLetter {
+		known[entry.TaskID] = true
+	}
+
+	tracked := make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		if known[task.ID] {
+			tracked[task.ID] = true
+		}
+	}
+	return tracked, nil
+}
+
+func (b *MemoryBroker) AddToDLQ(ctx context.Context, taskID, reason string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.removeDLQLocked(taskID)
+	b.deadLetter = append(b.deadLetter, DLQEntry{TaskID: taskID, Reason: reason, FailedAt: time.Now()})
+	return nil
+}
+
+func (b *MemoryBroker) ListDLQ(ctx context.Context) ([]DLQEntry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]DLQEntry(nil), b.deadLetter...), nil
+}
+
+func (b *MemoryBroker) RemoveFromDLQ(ctx context.Context, taskID string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.removeDLQLocked(taskID), nil
+}
+
+func (b *MemoryBroker) PurgeDLQ(ctx context.Context) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(b.deadLetter)
+	b.deadLetter = nil
+	return n, nil
+}
+
+func (b *MemoryBroker) removeDLQLocked(taskID string) bool {
+	for i, entry := range b.deadLetter {
+		if entry.TaskID == taskID {
+			b.deadLetter = append(b.deadLetter[:i], b.deadLetter[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (b *MemoryBroker) PublishTaskUpdate(ctx context.Context, taskID, status string) error {
+	b.publish(ChannelTaskUpdates, taskUpdateMessage(taskID, status))
+	return nil
+}
+
+func (b *MemoryBroker) PublishTaskEvent(ctx context.Context, task *models.Task) error {
+	data, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("failed to marshal task: %w", err)
+	}
+	b.publish(ChannelTaskUpdates, string(data))
+	return nil
+}
+
+func (b *MemoryBroker) PublishSystemHealth(ctx context.Context, health *models.SystemHealth) error {
+	data, err := json.Marshal(health)
+	if err != nil {
+		return fmt.Errorf("failed to marshal health metric: %w", err)
+	}
+	b.publish(ChannelSystemHealth, string(data))
+	return nil
+}
+
+func (b *MemoryBroker) PublishScalingEvent(ctx context.Context, event *models.ScalingEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal scaling event: %w", err)
+	}
+	b.publish(ChannelScalingEvents, string(data))
+	return nil
+}
+
+func (b *MemoryBroker) PublishCancellation(ctx context.Context, taskID string) error {
+	b.publish(ChannelTaskCancellations, taskID)
+	return nil
+}
+
+func (b *MemoryBroker) SubscribeSystemHealth(ctx context.Context) Subscription {
+	return b.subscribe(ChannelSystemHealth)
+}
+
+func (b *MemoryBroker) SubscribeTaskUpdates(ctx context.Context) Subscription {
+	return b.subscribe(ChannelTaskUpdates)
+}
+
+func (b *MemoryBroker) SubscribeScalingEvents(ctx context.Context) Subscription {
+	return b.subscribe(ChannelScalingEvents)
+}
+
+func (b *MemoryBroker) SubscribeCancellations(ctx context.Context) Subscription {
+	return b.subscribe(ChannelTaskCancellations)
+}
+
+func (b *MemoryBroker) publish(channel, message string) {
+	b.subMu.Lock()
+	defer b.subMu.Unlock()
+
+	for sub := range b.subscribers[channel] {
+		select {
+		case sub.messages <- message:
+		default:
+			// Subscriber is too far behind; drop like Redis would.
+		}
+	}
+}
+
+func (b *MemoryBroker) subscribe(channel string) Subscription {
+	b.subMu.Lock()
+	defer b.subMu.Unlock()
+
+	sub := &memorySubscription{
+		broker:   b,
+		channel:  channel,
+		messages: make(chan string, memorySubscriptionBuffer),
+	}
+	if b.subscribers[channel] == nil {
+		b.subscribers[channel] = make(map[*memorySubscription]struct{})
+	}
+	b.subscribers[channel][sub] = struct{}{}
+	return sub
+}
+
+type memorySubscription struct {
+	broker   *MemoryBroker
+	channel  string
+	messages chan string
+}
+
+func (s *memorySubscription) Messages() <-chan string {
+	return s.messages
+}
+
+func (s *memorySubscription) Close() error {
+	s.broker.subMu.Lock()
+	defer s.broker.subMu.Unlock()
+
+	if _, ok := s.broker.subscribers[s.channel][s]; ok {
+		delete(s.broker.subscribers[s.channel], s)
+		close(s.messages)
+	}
+	return nil
+}
+
+// readyEntry is a waiting task; lower scores are fetched first and seq keeps
+// equal scores in enqueue order.
+type readyEntry struct {
+	taskID string
+	score  float64
+	seq    uint64
+}
+
+// readyHeap is a container/heap of readyEntry ordered like a Redis ready set.
+type readyHeap []readyEntry
+
+func (h readyHeap) less(a, b readyEntry) bool {
+	if a.score != b.score {
+		return a.score < b.score
+	}
+	return a.seq < b.seq
+}
+
+func (h readyHeap) Len() int           { return len(h) }
+func (h readyHeap) Less(i, j int) bool { return h.less(h[i], h[j]) }
+func (h readyHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *readyHeap) Push(x any)        { *h = append(*h, x.(readyEntry)) }
+func (h *readyHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
@@ -0,0 +1,224 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+)
+
+func fetch(t *testing.T, b Broker, agentTypes ...string) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	taskID, err := b.FetchTask(ctx, "test-consumer", agentTypes...)
+	if err != nil {
+		t.Fatalf("FetchTask failed: %v", err)
+	}
+	return taskID
+}
+
+func queueDepth(t *testing.T, b Broker) int {
+	t.Helper()
+	depth, err := b.QueueDepth(context.Background())
+	if err != nil {
+		t.Fatalf("QueueDepth failed: %v", err)
+	}
+	return depth
+}
+
+func TestMemoryBrokerFetchOrder(t *testing.T) {
+	type queued struct {
+		id        string
+		agentType string
+		priority  int
+	}
+	tests := []struct {
+		name       string
+		opts       Options
+		tasks      []queued
+		agentTypes []string
+		want       []string
+	}{
+		{
+			name:  "highest priority first, FIFO within a priority",
+			tasks: []queued{{"low", "QA", 1}, {"high-1", "QA", 3}, {"medium", "QA", 2}, {"high-2", "QA", 3}},
+			want:  []string{"high-1", "high-2", "medium", "low"},
+		},
+		{
+			name:  "across agent types",
+			tasks: []queued{{"dev", "DEV", 1}, {"qa", "QA", 5}},
+			want:  []string{"qa", "dev"},
+		},
+		{
+			name:       "only the requested agent types",
+			tasks:      []queued{{"dev", "DEV", 5}, {"qa", "QA", 1}},
+			agentTypes: []string{"QA"},
+			want:       []string{"qa"},
+		},
+		{
+			name: "weighted mode serves the drawn band first",
+			opts: Options{
+				DequeueMode: DequeueWeighted,
+				BandWeights: map[string]int{BandHigh: 0, BandMedium: 0, BandLow: 1},
+			},
+			tasks: []queued{{"high", "QA", 3}, {"low", "QA", 1}, {"medium", "QA", 2}},
+			want:  []string{"low", "high", "medium"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			b := NewMemoryBroker(store, tt.opts)
+			for _, q := range tt.tasks {
+				if err := b.Enqueue(context.Background(), store.add(q.id, q.agentType, q.priority)); err != nil {
+					t.Fatalf("Enqueue failed: %v", err)
+				}
+			}
+			for _, want := range tt.want {
+				if got := fetch(t, b, tt.agentTypes...); got != want {
+					t.Fatalf("fetched %s, want %s", got, want)
+				}
+				if status := store.status(want); status != models.TaskStatusRunning {
+					t.Errorf("task %s is %s after fetch, want running", want, status)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryBrokerAging(t *testing.T) {
+	store := newFakeStore()
+	b := NewMemoryBroker(store, Options{AgingRate: 1})
+
+	// A low priority task that has waited five minutes beats a fresh high
+	// priority one at one level per minute
+	old := store.add("old-low", "QA", 1)
+	b.mu.Lock()
+	b.pushLocked(readyQueue(old.AgentType, old.Priority), old.ID, readyScore(old.Priority, time.Now().Add(-5*time.Minute), b.agingRate))
+	b.mu.Unlock()
+	if err := b.Enqueue(context.Background(), store.add("new-high", "QA", 3)); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+
+	if got := fetch(t, b); got != "old-low" {
+		t.Errorf("fetched %s first, want old-low", got)
+	}
+	if got := fetch(t, b); got != "new-high" {
+		t.Errorf("fetched %s second, want new-high", got)
+	}
+}
+
+func TestMemoryBrokerNeverQueuesTwice(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	b := NewMemoryBroker(store, Options{LeaseTimeout: time.Millisecond})
+	task := store.add("task", "QA", 2)
+
+	for i := 0; i < 3; i++ {
+		if err := b.Enqueue(ctx, task); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+	}
+	if depth := queueDepth(t, b); depth != 1 {
+		t.Fatalf("queue depth after repeated enqueues = %d, want 1", depth)
+	}
+
+	fetch(t, b)
+	// Handed back while still in flight, then reaped once the lease expires
+	store.setStatus(task.ID, models.TaskStatusPending)
+	if err := b.Enqueue(ctx, task); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	store.setStatus(task.ID, models.TaskStatusRunning)
+	time.Sleep(5 * time.Millisecond)
+	if _, err := b.ReapOrphans(ctx); err != nil {
+		t.Fatalf("ReapOrphans failed: %v", err)
+	}
+	if depth := queueDepth(t, b); depth != 1 {
+		t.Errorf("queue depth after reaping a queued task = %d, want 1", depth)
+	}
+}
+
+func TestMemoryBrokerReapOrphans(t *testing.T) {
+	tests := []struct {
+		name   string
+		status models.TaskStatus
+		reaped int
+		depth  int
+	}{
+		{"running task is requeued", models.TaskStatusRunning, 1, 1},
+		{"completed task is dropped", models.TaskStatusCompleted, 0, 0},
+		{"cancelled task is dropped", models.TaskStatusCancelled, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newFakeStore()
+			b := NewMemoryBroker(store, Options{LeaseTimeout: time.Millisecond})
+			if err := b.Enqueue(ctx, store.add("task", "QA", 2)); err != nil {
+				t.Fatalf("Enqueue failed: %v", err)
+			}
+			fetch(t, b)
+			store.setStatus("task", tt.status)
+			time.Sleep(5 * time.Millisecond)
+
+			reaped, err := b.ReapOrphans(ctx)
+			if err != nil {
+				t.Fatalf("ReapOrphans failed: %v", err)
+			}
+			if reaped != tt.reaped {
+				t.Errorf("reaped %d, want %d", reaped, tt.reaped)
+			}
+			if depth := queueDepth(t, b); depth != tt.depth {
+				t.Errorf("queue depth = %d, want %d", depth, tt.depth)
+			}
+			if tt.reaped > 0 && store.status("task") != models.TaskStatusPending {
+				t.Errorf("reaped task is %s, want pending", store.status("task"))
+			}
+		})
+	}
+}
+
+func TestMemoryBrokerClaimFailure(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	b := NewMemoryBroker(store, Options{})
+	if err := b.Enqueue(ctx, store.add("task", "QA", 2)); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+
+	store.claimErr = errors.New("connection refused")
+	if _, err := b.FetchTask(ctx, "test-consumer"); err == nil {
+		t.Fatal("FetchTask succeeded, want the claim error")
+	}
+	if depth := queueDepth(t, b); depth != 1 {
+		t.Fatalf("queue depth after failed claim = %d, want 1", depth)
+	}
+	if got := fetch(t, b); got != "task" {
+		t.Errorf("fetched %s after failed claim, want task", got)
+	}
+}
+
+func TestMemoryBrokerSkipsFinishedTasks(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	b := NewMemoryBroker(store, Options{})
+	for _, id := range []string{"cancelled", "live"} {
+		if err := b.Enqueue(ctx, store.add(id, "QA", 2)); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+	}
+	store.setStatus("cancelled", models.TaskStatusCancelled)
+
+	if got := fetch(t, b); got != "live" {
+		t.Errorf("fetched %s, want live", got)
+	}
+	if depth := queueDepth(t, b); depth != 0 {
+		t.Errorf("queue depth = %d, want 0", depth)
+	}
+}
@@ -3,130 +3,638 @@ package broker
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/YehiaGewily/Agent-Mesh/internal/models"
-	"github.com/YehiaGewily/Agent-Mesh/pkg/database"
 	"github.com/redis/go-redis/v9"
 )
 
 const (
-	QueueHigh   = "agent_high"
-	QueueMedium = "agent_medium"
-	QueueLow    = "agent_low"
+	// ReadyPrefix namespaces the sorted sets of task IDs waiting to be
+	// fetched, one per agent type and band, e.g. "agent_ready:DEVELOPER:high".
+	// Each is scored by readyScore so ZPOPMIN always yields the highest
+	// effective priority in the set.
+	ReadyPrefix = "agent_ready:"
+	// AgentTypesKey is the set of agent types that have ever been enqueued,
+	// so workers serving every type know which ready sets exist.
+	AgentTypesKey = "agent_types"
+	// ReadyNotifyPrefix namespaces the wakeup lists, one per agent type. A
+	// token is pushed whenever a task becomes ready, and idle workers block
+	// on them with BLPOP instead of polling the ready sets.
+	ReadyNotifyPrefix = "agent_ready_notify:"
+
+	// ProcessingPrefix namespaces the per-worker in-flight lists. A task ID
+	// lives in exactly one of them between FetchTask and Ack.
+	ProcessingPrefix = "agent_processing:"
+	// HeartbeatPrefix namespaces the liveness keys refreshed by each worker.
+	HeartbeatPrefix = "agent_worker:"
+
+	// LeasesKey is a sorted set of claimed task IDs scored by lease expiry
+	// (unix ms). LeaseOwnersKey maps each leased task ID to its consumer.
+	LeasesKey      = "agent_leases"
+	LeaseOwnersKey = "agent_lease_owners"
+
+	// DelayedKey is a sorted set of scheduled tasks scored by the unix ms
+	// time at which they become ready.
+	DelayedKey = "agent_delayed"
+	// DeadLetterKey is a hash of parked task IDs to their JSON-encoded
+	// DLQEntry.
+	DeadLetterKey = "agent_dlq"
 )
 
+// promoteBatchSize caps how many due tasks a single PromoteDue call moves.
+const promoteBatchSize = 100
+
+// maxReadyNotifications caps each wakeup list, so tokens pushed while no
+// worker is waiting do not pile up.
+const maxReadyNotifications = 64
+
+// fetchBlockTimeout bounds how long an idle FetchTask blocks on the wakeup
+// lists before looking at the ready sets again, in case a token went to a
+// worker that lost the race for the task.
+const fetchBlockTimeout = time.Second
+
+// DefaultLeaseTimeout is used when the broker is not given a lease timeout.
+const DefaultLeaseTimeout = 30 * time.Second
+
+// ErrLeaseLost is returned by RenewLease when the caller no longer holds the
+// lease, typically because it expired and the task was handed to another
+// worker.
+var ErrLeaseLost = errors.New("lease lost")
+
+// fetchScript pops the best-scored task from the ready sets in KEYS[1..n],
+// pushes it onto the consumer's in-flight list (KEYS[n+1]) and leases it
+// (KEYS[n+2], KEYS[n+3]) in one atomic step. The ready sets are split into
+// consecutive groups whose sizes follow in ARGV[4..]; the first group with
+// any task wins, and within it the lowest score.
+// ARGV: lease expiry (unix ms), consumer, n, group sizes...
+var fetchScript = redis.NewScript(`
+local n = tonumber(ARGV[3])
+local best, bestScore
+local first = 1
+for g = 4, #ARGV do
+	local last = first + tonumber(ARGV[g]) - 1
+	for i = first, last do
+		local head = redis.call('ZRANGE', KEYS[i], 0, 0, 'WITHSCORES')
+		if #head > 0 then
+			local score = tonumber(head[2])
+			if bestScore == nil or score < bestScore then
+				best, bestScore = i, score
+			end
+		end
+	end
+	if best ~= nil then
+		break
+	end
+	first = last + 1
+end
+if best == nil then
+	return false
+end
+local id = redis.call('ZPOPMIN', KEYS[best])[1]
+redis.call('LPUSH', KEYS[n + 1], id)
+redis.call('ZADD', KEYS[n + 2], ARGV[1], id)
+redis.call('HSET', KEYS[n + 3], id, ARGV[2])
+return id
+`)
+
+// requeueScript moves a task ID from an in-flight list back to its ready set
+// with the given score and drops its lease, but only if it is still in
+// flight. This keeps two reapers from re-enqueueing the same orphan twice.
+// If ARGV[3] is "0" the task is dropped from the in-flight list instead of
+// being re-added; otherwise a waiting worker is woken through KEYS[5].
+var requeueScript = redis.NewScript(`
+redis.call('ZREM', KEYS[3], ARGV[1])
+redis.call('HDEL', KEYS[4], ARGV[1])
+if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 1 then
+	if ARGV[3] == '1' then
+		redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+		redis.call('LPUSH', KEYS[5], 1)
+		redis.call('LTRIM', KEYS[5], 0, tonumber(ARGV[4]) - 1)
+	end
+	return 1
+end
+return 0
+`)
+
+// ackScript drops a task from an in-flight list and releases its lease, but
+// leaves the lease alone if it has since been granted to another consumer.
+var ackScript = redis.NewScript(`
+redis.call('LREM', KEYS[1], 1, ARGV[1])
+if redis.call('HGET', KEYS[3], ARGV[1]) == ARGV[2] then
+	redis.call('ZREM', KEYS[2], ARGV[1])
+	redis.call('HDEL', KEYS[3], ARGV[1])
+end
+return 1
+`)
+
+// promoteScript moves one scheduled task to its ready set with the given
+// score and wakes a waiting worker, unless another promoter already did.
+var promoteScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 1 then
+	redis.call('ZADD', KEYS[2], ARGV[3], ARGV[2])
+	redis.call('SADD', KEYS[3], ARGV[4])
+	redis.call('LPUSH', KEYS[4], 1)
+	redis.call('LTRIM', KEYS[4], 0, tonumber(ARGV[5]) - 1)
+	return 1
+end
+return 0
+`)
+
+// renewScript extends a lease only if it is still held by the caller.
+var renewScript = redis.NewScript(`
+if redis.call('HGET', KEYS[2], ARGV[1]) == ARGV[2] then
+	redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+// RedisBroker is the list-based broker: a sorted set of ready tasks ordered
+// by aged priority, and a Redis list per consumer holding its in-flight
+// tasks.
 type RedisBroker struct {
-	Client *redis.Client
-	DB     *database.DB
+	redisEvents
+	DB TaskStore
+
+	// leaseTimeout is how long a fetched task stays claimed without renewal.
+	leaseTimeout time.Duration
+	// agingRate is how many priority levels a task gains per minute of
+	// waiting.
+	agingRate float64
+	picker    bandPicker
 }
 
-func NewBroker(addr string, db *database.DB) *RedisBroker {
+func NewBroker(addr string, db TaskStore, opts Options) *RedisBroker {
 	rdb := redis.NewClient(&redis.Options{
 		Addr: addr,
 	})
+	opts = opts.withDefaults()
 	return &RedisBroker{
-		Client: rdb,
-		DB:     db,
+		redisEvents:  redisEvents{Client: rdb},
+		DB:           db,
+		leaseTimeout: opts.LeaseTimeout,
+		agingRate:    opts.AgingRate,
+		picker:       newBandPicker(opts.DequeueMode, opts.BandWeights),
 	}
 }
 
-func (b *RedisBroker) Enqueue(ctx context.Context, taskID string, priority int) error {
-	queue := QueueLow
-	if priority >= 3 {
-		queue = QueueHigh
-	} else if priority == 2 {
-		queue = QueueMedium
-	}
+// LeaseTimeout reports how long a fetched task stays claimed without renewal.
+func (b *RedisBroker) LeaseTimeout() time.Duration {
+	return b.leaseTimeout
+}
 
-	err := b.Client.LPush(ctx, queue, taskID).Err()
+func (b *RedisBroker) Enqueue(ctx context.Context, task *models.Task) error {
+	queue := readyQueue(task.AgentType, task.Priority)
+	_, err := b.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, queue, redis.Z{
+			Score:  readyScore(task.Priority, time.Now(), b.agingRate),
+			Member: task.ID,
+		})
+		pipe.SAdd(ctx, AgentTypesKey, task.AgentType)
+		notifyReady(ctx, pipe, readyNotifyKey(task.AgentType))
+		return nil
+	})
 	if err != nil {
 		return fmt.Errorf("failed to enqueue task to %s: %w", queue, err)
 	}
 	return nil
 }
 
-// FetchTask blocks until a task is available in the specified queues,
-// then immediately updates its status to 'running' in Postgres (Claim pattern).
-func (b *RedisBroker) FetchTask(ctx context.Context, queues ...string) (string, error) {
-	// Default priority order if no queues provided
-	if len(queues) == 0 {
-		queues = []string{QueueHigh, QueueMedium, QueueLow}
+func (b *RedisBroker) Schedule(ctx context.Context, task *models.Task, readyAt time.Time) error {
+	err := b.Client.ZAdd(ctx, DelayedKey, redis.Z{
+		Score:  float64(readyAt.UnixMilli()),
+		Member: delayedMember(task),
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to schedule task %s: %w", task.ID, err)
 	}
+	return nil
+}
 
-	// BLPop or BRPop. User asked for BRPOP.
-	// Redis BRPOP returns [listName, value]
-	result, err := b.Client.BRPop(ctx, 0*time.Second, queues...).Result()
+// PromoteDue moves scheduled tasks whose ready time has passed into the ready
+// set. Their aging starts from the moment they are promoted. Safe to run
+// from several nodes at once.
+func (b *RedisBroker) PromoteDue(ctx context.Context) (int, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	due, err := b.Client.ZRangeByScore(ctx, DelayedKey, &redis.ZRangeBy{Min: "-inf", Max: now, Count: promoteBatchSize}).Result()
 	if err != nil {
-		return "", fmt.Errorf("failed to fetch task: %w", err)
+		return 0, fmt.Errorf("failed to read due tasks: %w", err)
 	}
 
-	if len(result) < 2 {
-		return "", fmt.Errorf("invalid BRPOP result")
+	promoted := 0
+	for _, member := range due {
+		task, err := parseDelayedMember(member)
+		if err != nil {
+			b.Client.ZRem(ctx, DelayedKey, member)
+			continue
+		}
+		score := readyScore(task.Priority, time.Now(), b.agingRate)
+		keys := []string{DelayedKey, readyQueue(task.AgentType, task.Priority), AgentTypesKey, readyNotifyKey(task.AgentType)}
+		moved, err := promoteScript.Run(ctx, b.Client, keys, member, task.ID, score, task.AgentType, maxReadyNotifications).Int()
+		if err != nil {
+			return promoted, fmt.Errorf("failed to promote task %s: %w", task.ID, err)
+		}
+		promoted += moved
 	}
+	return promoted, nil
+}
 
-	taskID := result[1]
+// FetchTask waits until a task is available in the ready sets of the given
+// agent types, blocking on their wakeup lists while they are empty, and
+// atomically moves the next one, as chosen by the dequeue
+// mode, into the consumer's in-flight list and grants the consumer a lease
+// on it, then immediately updates its status to 'running' in Postgres (Claim
+// pattern). The caller must keep the lease alive with RenewLease and Ack the
+// task once it is done with it.
+func (b *RedisBroker) FetchTask(ctx context.Context, consumer string, agentTypes ...string) (string, error) {
+	processing := ProcessingKey(consumer)
 
-	// Claim Pattern: Update status to running immediately
-	err = b.DB.UpdateTaskStatus(ctx, taskID, models.TaskStatusRunning)
-	if err != nil {
-		return "", fmt.Errorf("failed to claim task %s: %w", taskID, err)
+	for {
+		types := agentTypes
+		if len(types) == 0 {
+			// Serve every type seen so far; new types may appear at any time.
+			known, err := b.Client.SMembers(ctx, AgentTypesKey).Result()
+			if err != nil {
+				return "", fmt.Errorf("failed to list agent types: %w", err)
+			}
+			types = known
+		}
+		queues := readyQueues(types)
+		if len(queues) == 0 {
+			// Nothing was ever enqueued, so there is no wakeup list to block on.
+			if err := sleepCtx(ctx, fetchPollInterval); err != nil {
+				return "", fmt.Errorf("failed to fetch task: %w", err)
+			}
+			continue
+		}
+		bands := make([]string, len(queues))
+		for i, queue := range queues {
+			bands[i] = bandOfQueue(queue)
+		}
+
+		// Weighted mode draws a new band order on every attempt.
+		keys := make([]string, 0, len(queues)+3)
+		args := []interface{}{time.Now().Add(b.leaseTimeout).UnixMilli(), consumer, len(queues)}
+		for _, group := range b.picker.order(bands) {
+			for _, i := range group {
+				keys = append(keys, queues[i])
+			}
+			args = append(args, len(group))
+		}
+		keys = append(keys, processing, LeasesKey, LeaseOwnersKey)
+
+		taskID, err := fetchScript.Run(ctx, b.Client, keys, args...).Text()
+		if err == redis.Nil {
+			if err := waitForWakeup(ctx, b.Client, readyNotifyKeys(types)); err != nil {
+				return "", fmt.Errorf("failed to fetch task: %w", err)
+			}
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to fetch task: %w", err)
+		}
+
+		if err := claim(ctx, b.DB, b, taskID); err != nil {
+			if errors.Is(err, errTaskFinished) {
+				if err := b.Ack(ctx, consumer, taskID); err != nil {
+					return "", err
+				}
+				continue
+			}
+			// Hand the task back rather than stranding it in our in-flight
+			// list, even if the claim failed because ctx was cancelled.
+			if _, rqErr := b.requeue(context.WithoutCancel(ctx), processing, taskID); rqErr != nil {
+				return "", fmt.Errorf("%w (requeue failed: %v)", err, rqErr)
+			}
+			return "", err
+		}
+		return taskID, nil
 	}
+}
 
-	// Notify Real-Time (Running)
-	b.PublishTaskUpdate(ctx, taskID, string(models.TaskStatusRunning))
+// notifyReady pushes a wakeup token onto the wakeup list key, keeping at
+// most maxReadyNotifications of them.
+func notifyReady(ctx context.Context, pipe redis.Pipeliner, key string) {
+	pipe.LPush(ctx, key, 1)
+	pipe.LTrim(ctx, key, 0, maxReadyNotifications-1)
+}
 
-	return taskID, nil
+// waitForWakeup blocks until a token is pushed onto one of the wakeup lists
+// keys, fetchBlockTimeout passes or ctx is done. A token may be stale, so
+// the caller must still look for work afterwards.
+func waitForWakeup(ctx context.Context, client *redis.Client, keys []string) error {
+	err := client.BLPop(ctx, fetchBlockTimeout, keys...).Err()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	return ctx.Err()
 }
 
-func (b *RedisBroker) AddToDLQ(ctx context.Context, taskID string) error {
-	err := b.Client.RPush(ctx, "agent_dead_letter", taskID).Err()
+// Ack removes a task from the consumer's in-flight list and releases its
+// lease. It must be called once the task has completed, failed permanently
+// or been re-enqueued.
+func (b *RedisBroker) Ack(ctx context.Context, consumer, taskID string) error {
+	err := ackScript.Run(ctx, b.Client, []string{ProcessingKey(consumer), LeasesKey, LeaseOwnersKey}, taskID, consumer).Err()
 	if err != nil {
-		return fmt.Errorf("failed to add to DLQ: %w", err)
+		return fmt.Errorf("failed to ack task %s: %w", taskID, err)
 	}
 	return nil
 }
 
-func (b *RedisBroker) PublishTaskUpdate(ctx context.Context, taskID, status string) error {
-	msg := fmt.Sprintf(`{"task_id":"%s","status":"%s"}`, taskID, status)
-	err := b.Client.Publish(ctx, "task_updates", msg).Err()
+// RenewLease pushes the lease expiry of a task held by consumer another
+// LeaseTimeout into the future. It returns ErrLeaseLost if the consumer no
+// longer holds the lease.
+func (b *RedisBroker) RenewLease(ctx context.Context, consumer, taskID string) error {
+	expiry := time.Now().Add(b.leaseTimeout).UnixMilli()
+	renewed, err := renewScript.Run(ctx, b.Client, []string{LeasesKey, LeaseOwnersKey}, taskID, consumer, expiry).Int()
 	if err != nil {
-		return fmt.Errorf("failed to publish update: %w", err)
+		return fmt.Errorf("failed to renew lease on %s: %w", taskID, err)
+	}
+	if renewed == 0 {
+		return fmt.Errorf("task %s: %w", taskID, ErrLeaseLost)
 	}
 	return nil
 }
 
-func (b *RedisBroker) PublishTaskEvent(ctx context.Context, task *models.Task) error {
-	data, err := json.Marshal(task)
+// Heartbeat marks the consumer as alive for ttl. Consumers whose heartbeat
+// has expired are considered dead and their in-flight tasks get reaped.
+func (b *RedisBroker) Heartbeat(ctx context.Context, consumer string, ttl time.Duration) error {
+	err := b.Client.Set(ctx, HeartbeatPrefix+consumer, time.Now().Unix(), ttl).Err()
 	if err != nil {
-		return fmt.Errorf("failed to marshal task: %w", err)
+		return fmt.Errorf("failed to write heartbeat: %w", err)
 	}
+	return nil
+}
 
-	err = b.Client.Publish(ctx, "task_updates", data).Err()
+// ReapOrphans returns the in-flight tasks of dead consumers, as well as any
+// task whose lease has expired, to their priority queue and resets them to
+// 'pending'. It reports how many tasks were requeued.
+func (b *RedisBroker) ReapOrphans(ctx context.Context) (int, error) {
+	reaped, err := b.reapExpiredLeases(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to publish task event: %w", err)
+		return reaped, err
 	}
-	return nil
+
+	iter := b.Client.Scan(ctx, 0, ProcessingPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		processing := iter.Val()
+		consumer := strings.TrimPrefix(processing, ProcessingPrefix)
+
+		alive, err := b.Client.Exists(ctx, HeartbeatPrefix+consumer).Result()
+		if err != nil {
+			return reaped, fmt.Errorf("failed to check heartbeat for %s: %w", consumer, err)
+		}
+		if alive > 0 {
+			continue
+		}
+
+		taskIDs, err := b.Client.LRange(ctx, processing, 0, -1).Result()
+		if err != nil {
+			return reaped, fmt.Errorf("failed to read in-flight list %s: %w", processing, err)
+		}
+		for _, taskID := range taskIDs {
+			moved, err := b.requeue(ctx, processing, taskID)
+			if err != nil {
+				return reaped, err
+			}
+			if moved {
+				reaped++
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return reaped, fmt.Errorf("failed to scan in-flight lists: %w", err)
+	}
+	return reaped, nil
 }
 
-func (b *RedisBroker) PublishSystemHealth(ctx context.Context, health *models.SystemHealth) error {
-	data, err := json.Marshal(health)
+func (b *RedisBroker) reapExpiredLeases(ctx context.Context) (int, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	expired, err := b.Client.ZRangeByScore(ctx, LeasesKey, &redis.ZRangeBy{Min: "-inf", Max: now}).Result()
 	if err != nil {
-		return fmt.Errorf("failed to marshal health metric: %w", err)
+		return 0, fmt.Errorf("failed to read expired leases: %w", err)
 	}
 
-	err = b.Client.Publish(ctx, "system_health", data).Err()
+	reaped := 0
+	for _, taskID := range expired {
+		owner, err := b.Client.HGet(ctx, LeaseOwnersKey, taskID).Result()
+		if err != nil && err != redis.Nil {
+			return reaped, fmt.Errorf("failed to read lease owner of %s: %w", taskID, err)
+		}
+		moved, err := b.requeue(ctx, ProcessingKey(owner), taskID)
+		if err != nil {
+			return reaped, err
+		}
+		if moved {
+			reaped++
+		}
+	}
+	return reaped, nil
+}
+
+// requeue moves taskID from the given in-flight list back to the ready set,
+// marking it pending again if it was running. The task is aged from its
+// creation time, so it does not lose its place to tasks that arrived while
+// it was in flight. A task that is already pending, e.g. because its claim
+// failed or another reaper reset it, is requeued all the same; the script
+// only moves it if it is still in flight. Tasks that were cancelled or
+// finished meanwhile are dropped instead. It reports whether the task was
+// requeued.
+func (b *RedisBroker) requeue(ctx context.Context, processing, taskID string) (bool, error) {
+	task, err := b.DB.GetTask(ctx, taskID)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up orphaned task %s: %w", taskID, err)
+	}
+
+	reset, err := b.DB.TransitionTask(ctx, taskID, models.TaskStatusRunning, models.TaskStatusPending)
 	if err != nil {
-		return fmt.Errorf("failed to publish health metric: %w", err)
+		return false, fmt.Errorf("failed to reset task %s to pending: %w", taskID, err)
+	}
+	requeue := "1"
+	if !reset {
+		current, err := b.DB.GetTask(ctx, taskID)
+		if err != nil {
+			return false, fmt.Errorf("failed to look up orphaned task %s: %w", taskID, err)
+		}
+		if current.Status != models.TaskStatusPending {
+			requeue = "0"
+		}
+	}
+
+	score := readyScore(task.Priority, task.CreatedAt, b.agingRate)
+	keys := []string{processing, readyQueue(task.AgentType, task.Priority), LeasesKey, LeaseOwnersKey, readyNotifyKey(task.AgentType)}
+	moved, err := requeueScript.Run(ctx, b.Client, keys, taskID, score, requeue, maxReadyNotifications).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to requeue task %s: %w", taskID, err)
+	}
+	if moved == 0 || requeue == "0" {
+		return false, nil
+	}
+
+	if reset {
+		b.PublishTaskUpdate(ctx, taskID, string(models.TaskStatusPending))
+	}
+	return true, nil
+}
+
+// readyQueue returns the ready set for tasks of the given agent type and
+// priority.
+func readyQueue(agentType string, priority int) string {
+	return ReadyPrefix + agentType + ":" + bandForPriority(priority)
+}
+
+// readyNotifyKey is the wakeup list of an agent type.
+func readyNotifyKey(agentType string) string {
+	return ReadyNotifyPrefix + agentType
+}
+
+// readyNotifyKeys returns the wakeup lists of the given agent types.
+func readyNotifyKeys(agentTypes []string) []string {
+	keys := make([]string, len(agentTypes))
+	for i, agentType := range agentTypes {
+		keys[i] = readyNotifyKey(agentType)
+	}
+	return keys
+}
+
+// readyQueues returns every ready set of the given agent types.
+func readyQueues(agentTypes []string) []string {
+	queues := make([]string, 0, len(agentTypes)*len(Bands))
+	for _, agentType := range agentTypes {
+		for _, band := range Bands {
+			queues = append(queues, ReadyPrefix+agentType+":"+band)
+		}
+	}
+	return queues
+}
+
+// ProcessingKey returns the in-flight list used by the given consumer.
+func ProcessingKey(consumer string) string {
+	return ProcessingPrefix + consumer
+}
+
+func (b *RedisBroker) QueueDepth(ctx context.Context, agentTypes ...string) (int, error) {
+	if len(agentTypes) == 0 {
+		known, err := b.Client.SMembers(ctx, AgentTypesKey).Result()
+		if err != nil {
+			return 0, fmt.Errorf("failed to list agent types: %w", err)
+		}
+		agentTypes = known
+	}
+
+	queues := readyQueues(agentTypes)
+	cmds := make([]*redis.IntCmd, len(queues))
+	_, err := b.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, queue := range queues {
+			cmds[i] = pipe.ZCard(ctx, queue)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure queues: %w", err)
+	}
+	depth := 0
+	for _, cmd := range cmds {
+		depth += int(cmd.Val())
+	}
+	return depth, nil
+}
+
+func (b *RedisBroker) Remove(ctx context.Context, task *models.Task) error {
+	_, err := b.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, readyQueue(task.AgentType, task.Priority), task.ID)
+		pipe.ZRem(ctx, DelayedKey, delayedMember(task))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove task %s: %w", task.ID, err)
+	}
+	return nil
+}
+
+func (b *RedisBroker) Tracked(ctx context.Context, tasks []*models.Task) (map[string]bool, error) {
+	cmds := make([][]redis.Cmder, len(tasks))
+	_, err := b.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, task := range tasks {
+			cmds[i] = []redis.Cmder{
+				pipe.ZScore(ctx, readyQueue(task.AgentType, task.Priority), task.ID),
+				// Every in-flight task holds a lease until it is acked.
+				pipe.ZScore(ctx, LeasesKey, task.ID),
+				pipe.ZScore(ctx, DelayedKey, delayedMember(task)),
+				pipe.HGet(ctx, DeadLetterKey, task.ID),
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to look up tasks: %w", err)
+	}
+
+	tracked := make(map[string]bool, len(tasks))
+	for i, task := range tasks {
+		for _, cmd := range cmds[i] {
+			switch err := cmd.Err(); {
+			case err == nil:
+				tracked[task.ID] = true
+			case !errors.Is(err, redis.Nil):
+				return nil, fmt.Errorf("failed to look up task %s: %w", task.ID, err)
+			}
+		}
+	}
+	return tracked, nil
+}
+
+func (b *RedisBroker) AddToDLQ(ctx context.Context, taskID, reason string) error {
+	data, err := json.Marshal(DLQEntry{TaskID: taskID, Reason: reason, FailedAt: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal DLQ entry: %w", err)
+	}
+	if err := b.Client.HSet(ctx, DeadLetterKey, taskID, data).Err(); err != nil {
+		return fmt.Errorf("failed to add to DLQ: %w", err)
 	}
 	return nil
 }
 
-func (b *RedisBroker) SubscribeSystemHealth(ctx context.Context) *redis.PubSub {
-	return b.Client.Subscribe(ctx, "system_health")
+func (b *RedisBroker) ListDLQ(ctx context.Context) ([]DLQEntry, error) {
+	raw, err := b.Client.HGetAll(ctx, DeadLetterKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DLQ: %w", err)
+	}
+	entries := make([]DLQEntry, 0, len(raw))
+	for taskID, data := range raw {
+		var entry DLQEntry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			// Keep the task visible even if its metadata is unreadable.
+			entry = DLQEntry{TaskID: taskID}
+		}
+		entries = append(entries, entry)
+	}
+	sortDLQ(entries)
+	return entries, nil
+}
+
+func (b *RedisBroker) RemoveFromDLQ(ctx context.Context, taskID string) (bool, error) {
+	n, err := b.Client.HDel(ctx, DeadLetterKey, taskID).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to remove %s from DLQ: %w", taskID, err)
+	}
+	return n > 0, nil
 }
 
-func (b *RedisBroker) SubscribeTaskUpdates(ctx context.Context) *redis.PubSub {
-	return b.Client.Subscribe(ctx, "task_updates")
+func (b *RedisBroker) PurgeDLQ(ctx context.Context) (int, error) {
+	var n *redis.IntCmd
+	_, err := b.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		n = pipe.HLen(ctx, DeadLetterKey)
+		pipe.Del(ctx, DeadLetterKey)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge DLQ: %w", err)
+	}
+	return int(n.Val()), nil
 }
@@ -0,0 +1,148 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// testRedisDB is the logical database the Redis tests use. It is flushed
+// before and after every test.
+const testRedisDB = 15
+
+// testRedisClient connects to database testRedisDB of the server at
+// TEST_REDIS_ADDR, skipping the test if that is unset.
+func testRedisClient(t *testing.T) *redis.Client {
+	t.Helper()
+	addr := os.Getenv("TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("TEST_REDIS_ADDR not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr, DB: testRedisDB})
+	ctx := context.Background()
+	if err := client.FlushDB(ctx).Err(); err != nil {
+		t.Fatalf("failed to flush test database: %v", err)
+	}
+	t.Cleanup(func() {
+		client.FlushDB(ctx)
+		client.Close()
+	})
+	return client
+}
+
+func newTestRedisBroker(t *testing.T, store TaskStore) *RedisBroker {
+	t.Helper()
+	client := testRedisClient(t)
+	b := NewBroker(client.Options().Addr, store, Options{})
+	b.Client.Close()
+	b.Client = client
+	return b
+}
+
+// assertReady checks whether taskID waits in its ready set and that nothing
+// is left in consumer's in-flight list or leased.
+func assertReady(t *testing.T, b *RedisBroker, task *models.Task, consumer string, ready bool) {
+	t.Helper()
+	ctx := context.Background()
+	_, err := b.Client.ZScore(ctx, readyQueue(task.AgentType, task.Priority), task.ID).Result()
+	if got := err == nil; got != ready {
+		t.Errorf("task in ready set = %v, want %v (err %v)", got, ready, err)
+	}
+	if n := b.Client.LLen(ctx, ProcessingKey(consumer)).Val(); n != 0 {
+		t.Errorf("in-flight list holds %d tasks, want 0", n)
+	}
+	if err := b.Client.ZScore(ctx, LeasesKey, task.ID).Err(); err != redis.Nil {
+		t.Errorf("task is still leased (err %v)", err)
+	}
+}
+
+func TestRedisBrokerClaimFailureRequeues(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	b := newTestRedisBroker(t, store)
+	task := store.add("task", "QA", 2)
+	if err := b.Enqueue(ctx, task); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+
+	store.claimErr = errors.New("connection refused")
+	if _, err := b.FetchTask(ctx, "consumer", "QA"); err == nil {
+		t.Fatal("FetchTask succeeded, want the claim error")
+	}
+	if status := store.status(task.ID); status != models.TaskStatusPending {
+		t.Errorf("task is %s after failed claim, want pending", status)
+	}
+	assertReady(t, b, task, "consumer", true)
+
+	fetchCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	taskID, err := b.FetchTask(fetchCtx, "consumer", "QA")
+	if err != nil {
+		t.Fatalf("FetchTask failed: %v", err)
+	}
+	if taskID != task.ID {
+		t.Errorf("fetched %s, want %s", taskID, task.ID)
+	}
+}
+
+func TestRedisBrokerReapsExpiredLeases(t *testing.T) {
+	tests := []struct {
+		name   string
+		status models.TaskStatus
+		reaped int
+		ready  bool
+	}{
+		{"running task is reset and requeued", models.TaskStatusRunning, 1, true},
+		// e.g. the claim failed, or the worker died between marking a retry
+		// pending and scheduling it
+		{"pending task is requeued", models.TaskStatusPending, 1, true},
+		{"completed task is dropped", models.TaskStatusCompleted, 0, false},
+		{"cancelled task is dropped", models.TaskStatusCancelled, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newFakeStore()
+			b := newTestRedisBroker(t, store)
+			task := store.add("task", "QA", 2)
+			store.setStatus(task.ID, tt.status)
+
+			// Strand the task in a live consumer's in-flight list with an
+			// expired lease
+			_, err := b.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.LPush(ctx, ProcessingKey("consumer"), task.ID)
+				pipe.ZAdd(ctx, LeasesKey, redis.Z{Score: float64(time.Now().Add(-time.Second).UnixMilli()), Member: task.ID})
+				pipe.HSet(ctx, LeaseOwnersKey, task.ID, "consumer")
+				pipe.Set(ctx, HeartbeatPrefix+"consumer", "1", time.Minute)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("failed to set up in-flight task: %v", err)
+			}
+
+			reaped, err := b.ReapOrphans(ctx)
+			if err != nil {
+				t.Fatalf("ReapOrphans failed: %v", err)
+			}
+			if reaped != tt.reaped {
+				t.Errorf("reaped %d, want %d", reaped, tt.reaped)
+			}
+			assertReady(t, b, task, "consumer", tt.ready)
+			if tt.ready && store.status(task.ID) != models.TaskStatusPending {
+				t.Errorf("reaped task is %s, want pending", store.status(task.ID))
+			}
+
+			// A second pass finds nothing left to do
+			if reaped, err := b.ReapOrphans(ctx); err != nil || reaped != 0 {
+				t.Errorf("second ReapOrphans = %d, %v; want 0, nil", reaped, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,851 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// StreamPrefix namespaces the streams, one per agent type and priority
+	// level; a DEVELOPER task with priority 3 is appended to
+	// "agent_stream:DEVELOPER:p3".
+	StreamPrefix = "agent_stream:"
+	// StreamLevels is a sorted set of every stream in use, scored by its
+	// priority.
+	StreamLevels = "agent_stream_levels"
+	// StreamDeadLetter is the stream of parked tasks; each entry carries the
+	// task ID and the reason it was parked.
+	StreamDeadLetter = "agent_stream_dead_letter"
+	// StreamDelayed holds scheduled tasks, scored by ready time (unix ms),
+	// until PromoteDue appends them to their stream.
+	StreamDelayed = "agent_stream_delayed"
+	// StreamNotifyPrefix namespaces the wakeup lists, one per agent type,
+	// that idle workers block on; see ReadyNotifyPrefix.
+	StreamNotifyPrefix = "agent_stream_notify:"
+
+	// StreamGroup is the consumer group every worker reads through.
+	StreamGroup = "agent_workers"
+)
+
+// promoteStreamScript appends one scheduled task to its stream and wakes a
+// waiting worker, unless another promoter already did.
+var promoteStreamScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 1 then
+	redis.call('XADD', KEYS[2], '*', 'task_id', ARGV[2])
+	redis.call('ZADD', KEYS[3], ARGV[3], KEYS[2])
+	redis.call('LPUSH', KEYS[4], 1)
+	redis.call('LTRIM', KEYS[4], 0, tonumber(ARGV[4]) - 1)
+	return 1
+end
+return 0
+`)
+
+// DefaultMaxDeliveries is how often a stream entry may be delivered before
+// it is moved to the dead-letter stream instead of being reclaimed again.
+const DefaultMaxDeliveries = 5
+
+// StreamBroker is a Broker built on Redis Streams, one stream per agent type
+// and priority level, all read through a single consumer group. The group's
+// pending-entries list tracks in-flight tasks, idle time acts as the lease,
+// and entries whose consumer stops acking are reclaimed with XAUTOCLAIM by
+// the next worker that fetches. Aging uses the timestamp embedded in each
+// entry ID.
+type StreamBroker struct {
+	redisEvents
+	DB TaskStore
+
+	leaseTimeout  time.Duration
+	agingRate     float64
+	picker        bandPicker
+	maxDeliveries int64
+
+	mu sync.Mutex
+	// groups records the streams whose consumer group is known to exist.
+	groups map[string]bool
+	// inflight maps the tasks fetched through this broker to their stream
+	// entries, oldest first, which are needed to ack or renew them. A task
+	// has several entries when it was enqueued again, e.g. handed back by
+	// Drain, and fetched by another slot of the same consumer before the
+	// first delivery was acked.
+	inflight map[inflightKey][]streamEntry
+}
+
+type inflightKey struct {
+	consumer string
+	taskID   string
+}
+
+type streamEntry struct {
+	stream string
+	id     string
+}
+
+// streamLevel is one agent type's stream for a single priority.
+type streamLevel struct {
+	stream    string
+	agentType string
+	priority  int
+}
+
+func NewStreamBroker(addr string, db TaskStore, opts Options) *StreamBroker {
+	rdb := redis.NewClient(&redis.Options{
+		Addr: addr,
+	})
+	opts = opts.withDefaults()
+	return &StreamBroker{
+		redisEvents:   redisEvents{Client: rdb},
+		DB:            db,
+		leaseTimeout:  opts.LeaseTimeout,
+		agingRate:     opts.AgingRate,
+		picker:        newBandPicker(opts.DequeueMode, opts.BandWeights),
+		maxDeliveries: DefaultMaxDeliveries,
+		groups:        make(map[string]bool),
+		inflight:      make(map[inflightKey][]streamEntry),
+	}
+}
+
+// LeaseTimeout reports how long an entry may sit idle in a consumer's
+// pending list before another worker reclaims it.
+func (b *StreamBroker) LeaseTimeout() time.Duration {
+	return b.leaseTimeout
+}
+
+func (b *StreamBroker) Enqueue(ctx context.Context, task *models.Task) error {
+	stream := streamFor(task.AgentType, task.Priority)
+	if err := b.ensureGroup(ctx, stream); err != nil {
+		return err
+	}
+
+	_, err := b.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: stream,
+			Values: map[string]interface{}{"task_id": task.ID},
+		})
+		pipe.ZAdd(ctx, StreamLevels, redis.Z{Score: float64(task.Priority), Member: stream})
+		notifyReady(ctx, pipe, streamNotifyKey(task.AgentType))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enqueue task to %s: %w", stream, err)
+	}
+	return nil
+}
+
+func (b *StreamBroker) Schedule(ctx context.Context, task *models.Task, readyAt time.Time) error {
+	err := b.Client.ZAdd(ctx, StreamDelayed, redis.Z{
+		Score:  float64(readyAt.UnixMilli()),
+		Member: delayedMember(task),
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to schedule task %s: %w", task.ID, err)
+	}
+	return nil
+}
+
+// PromoteDue appends scheduled tasks whose ready time has passed to their
+// stream. Safe to run from several nodes at once.
+func (b *StreamBroker) PromoteDue(ctx context.Context) (int, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	due, err := b.Client.ZRangeByScore(ctx, StreamDelayed, &redis.ZRangeBy{Min: "-inf", Max: now, Count: promoteBatchSize}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read due tasks: %w", err)
+	}
+
+	promoted := 0
+	for _, member := range due {
+		task, err := parseDelayedMember(member)
+		if err != nil {
+			b.Client.ZRem(ctx, StreamDelayed, member)
+			continue
+		}
+		stream := streamFor(task.AgentType, task.Priority)
+		if err := b.ensureGroup(ctx, stream); err != nil {
+			return promoted, err
+		}
+		keys := []string{StreamDelayed, stream, StreamLevels, streamNotifyKey(task.AgentType)}
+		moved, err := promoteStreamScript.Run(ctx, b.Client, keys, member, task.ID, task.Priority, maxReadyNotifications).Int()
+		if err != nil {
+			return promoted, fmt.Errorf("failed to promote task %s: %w", task.ID, err)
+		}
+		promoted += moved
+	}
+	return promoted, nil
+}
+
+// FetchTask first reclaims entries that have been idle longer than the lease
+// timeout, then reads the next undelivered entry across the streams of the
+// given agent types (every type by default), as chosen by the dequeue mode.
+// While there is nothing to read it blocks on the wakeup lists of those
+// types. The fetched task is claimed in Postgres before it is returned.
+func (b *StreamBroker) FetchTask(ctx context.Context, consumer string, agentTypes ...string) (string, error) {
+	for {
+		levels, err := b.levels(ctx, agentTypes)
+		if err != nil {
+			return "", err
+		}
+
+		for _, level := range levels {
+			msg, err := b.reclaim(ctx, consumer, level.stream)
+			if err != nil {
+				return "", err
+			}
+			if msg != nil {
+				if taskID, err := b.deliver(ctx, consumer, level.stream, msg); err != nil || taskID != "" {
+					return taskID, err
+				}
+			}
+		}
+
+		stream, err := b.pickNext(ctx, levels)
+		if err != nil {
+			return "", err
+		}
+		if stream != "" {
+			msg, err := b.readNew(ctx, consumer, stream, -1)
+			if err != nil {
+				return "", err
+			}
+			if msg != nil {
+				if taskID, err := b.deliver(ctx, consumer, stream, msg); err != nil || taskID != "" {
+					return taskID, err
+				}
+			}
+			// Someone else took it first, or it was stale; look again
+			// straight away.
+			continue
+		}
+
+		if err := b.waitForTask(ctx, agentTypes, levels); err != nil {
+			return "", fmt.Errorf("failed to fetch task: %w", err)
+		}
+	}
+}
+
+// waitForTask blocks until a task is enqueued or promoted for one of
+// agentTypes (the types of levels if none are given), fetchBlockTimeout
+// passes or ctx is done. Entries abandoned by other consumers are picked up
+// on the next pass once the block times out.
+func (b *StreamBroker) waitForTask(ctx context.Context, agentTypes []string, levels []streamLevel) error {
+	types := agentTypes
+	if len(types) == 0 {
+		seen := make(map[string]bool, len(levels))
+		for _, level := range levels {
+			if !seen[level.agentType] {
+				seen[level.agentType] = true
+				types = append(types, level.agentType)
+			}
+		}
+	}
+	if len(types) == 0 {
+		// Nothing was ever enqueued, so there is no wakeup list to block on.
+		return sleepCtx(ctx, fetchPollInterval)
+	}
+
+	keys := make([]string, len(types))
+	for i, agentType := range types {
+		keys[i] = streamNotifyKey(agentType)
+	}
+	return waitForWakeup(ctx, b.Client, keys)
+}
+
+// levels resolves the streams in use for the given agent types, highest
+// priority first. With no agent types every stream in use is returned.
+func (b *StreamBroker) levels(ctx context.Context, agentTypes []string) ([]streamLevel, error) {
+	known, err := b.Client.ZRevRangeWithScores(ctx, StreamLevels, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list priority streams: %w", err)
+	}
+
+	wanted := make(map[string]bool, len(agentTypes))
+	for _, agentType := range agentTypes {
+		wanted[agentType] = true
+	}
+	levels := make([]streamLevel, 0, len(known))
+	for _, z := range known {
+		stream, _ := z.Member.(string)
+		agentType, ok := streamAgentType(stream)
+		if !ok || (len(wanted) > 0 && !wanted[agentType]) {
+			continue
+		}
+		levels = append(levels, streamLevel{stream: stream, agentType: agentType, priority: int(z.Score)})
+	}
+	return levels, nil
+}
+
+// pickNext peeks at the next undelivered entry of every level and returns
+// the stream to read from next, or "" if all are drained. Levels are grouped
+// into bands by the dequeue mode; within the first band that has entries the
+// one with the highest effective priority wins.
+func (b *StreamBroker) pickNext(ctx context.Context, levels []streamLevel) (string, error) {
+	if len(levels) == 0 {
+		return "", nil
+	}
+	for _, level := range levels {
+		if err := b.ensureGroup(ctx, level.stream); err != nil {
+			return "", err
+		}
+	}
+
+	groupCmds := make([]*redis.XInfoGroupsCmd, len(levels))
+	_, err := b.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, level := range levels {
+			groupCmds[i] = pipe.XInfoGroups(ctx, level.stream)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect consumer groups: %w", err)
+	}
+
+	nextCmds := make([]*redis.XMessageSliceCmd, len(levels))
+	_, err = b.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, level := range levels {
+			lastID := "0-0"
+			groups, _ := groupCmds[i].Result()
+			for _, g := range groups {
+				if g.Name == StreamGroup {
+					lastID = g.LastDeliveredID
+				}
+			}
+			nextCmds[i] = pipe.XRangeN(ctx, level.stream, "("+lastID, "+", 1)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to peek at priority streams: %w", err)
+	}
+
+	bands := make([]string, len(levels))
+	for i, level := range levels {
+		bands[i] = bandForPriority(level.priority)
+	}
+
+	best, bestScore := "", 0.0
+	for _, group := range b.picker.order(bands) {
+		for _, i := range group {
+			msgs, _ := nextCmds[i].Result()
+			if len(msgs) == 0 {
+				continue
+			}
+			score := readyScore(levels[i].priority, entryTime(msgs[0].ID), b.agingRate)
+			if best == "" || score < bestScore {
+				best, bestScore = levels[i].stream, score
+			}
+		}
+		if best != "" {
+			break
+		}
+	}
+	return best, nil
+}
+
+// readNew reads one never-delivered entry from stream. A negative block
+// makes the read non-blocking.
+func (b *StreamBroker) readNew(ctx context.Context, consumer, stream string, block time.Duration) (*redis.XMessage, error) {
+	res, err := b.Client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    StreamGroup,
+		Consumer: consumer,
+		Streams:  []string{stream, ">"},
+		Count:    1,
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from %s: %w", stream, err)
+	}
+	if len(res) == 0 || len(res[0].Messages) == 0 {
+		return nil, nil
+	}
+	return &res[0].Messages[0], nil
+}
+
+// reclaim takes over one entry of stream whose consumer has not acked or
+// renewed it within the lease timeout. Entries that have already been
+// delivered maxDeliveries times are dead-lettered instead.
+func (b *StreamBroker) reclaim(ctx context.Context, consumer, stream string) (*redis.XMessage, error) {
+	for {
+		msgs, _, err := b.Client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    StreamGroup,
+			Consumer: consumer,
+			MinIdle:  b.leaseTimeout,
+			Start:    "0-0",
+			Count:    1,
+		}).Result()
+		if err != nil && err != redis.Nil {
+			return nil, fmt.Errorf("failed to reclaim from %s: %w", stream, err)
+		}
+		if len(msgs) == 0 {
+			return nil, nil
+		}
+		msg := msgs[0]
+
+		pending, err := b.Client.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: stream,
+			Group:  StreamGroup,
+			Start:  msg.ID,
+			End:    msg.ID,
+			Count:  1,
+		}).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read delivery count of %s: %w", msg.ID, err)
+		}
+		if len(pending) == 0 || pending[0].RetryCount <= b.maxDeliveries {
+			return &msg, nil
+		}
+
+		if err := b.deadLetter(ctx, stream, msg, pending[0].RetryCount); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// deliver claims the task behind msg for the consumer that read it. It
+// returns an empty task ID if the task must not run again; its entry is
+// dropped in that case.
+func (b *StreamBroker) deliver(ctx context.Context, consumer, stream string, msg *redis.XMessage) (string, error) {
+	taskID, _ := msg.Values["task_id"].(string)
+	if taskID == "" {
+		// Not one of ours; drop it so it is not redelivered forever.
+		b.Client.XAck(ctx, stream, StreamGroup, msg.ID)
+		return "", fmt.Errorf("stream entry %s in %s has no task_id", msg.ID, stream)
+	}
+
+	entry := streamEntry{stream: stream, id: msg.ID}
+	key := inflightKey{consumer: consumer, taskID: taskID}
+	b.mu.Lock()
+	b.inflight[key] = append(b.inflight[key], entry)
+	b.mu.Unlock()
+
+	// If the claim fails the entry stays pending and is reclaimed later.
+	if err := claim(ctx, b.DB, b, taskID); err != nil {
+		b.untrack(key, entry)
+		if errors.Is(err, errTaskFinished) {
+			return "", b.ack(ctx, taskID, entry)
+		}
+		return "", err
+	}
+	return taskID, nil
+}
+
+// deadLetter moves an entry that keeps getting abandoned to the dead-letter
+// stream and marks its task as permanently failed. Entries of tasks that are
+// no longer running (cancelled or finished meanwhile) are just dropped.
+func (b *StreamBroker) deadLetter(ctx context.Context, stream string, msg redis.XMessage, deliveries int64) error {
+	taskID, _ := msg.Values["task_id"].(string)
+	if taskID != "" {
+		failed, err := b.DB.FinishTask(ctx, taskID, models.TaskPermanentFail, nil, "")
+		if err != nil {
+			return fmt.Errorf("failed to mark %s as PERMANENT_FAILURE: %w", taskID, err)
+		}
+		if !failed {
+			// An earlier call may have marked the task but failed to add it
+			// to the dead-letter stream; finish that job now
+			task, err := b.DB.GetTask(ctx, taskID)
+			if err != nil {
+				return fmt.Errorf("failed to look up abandoned task %s: %w", taskID, err)
+			}
+			failed = task.Status == models.TaskPermanentFail
+		}
+		if failed {
+			reason := fmt.Sprintf("abandoned after %d deliveries", deliveries)
+			if err := b.AddToDLQ(ctx, taskID, reason); err != nil {
+				return err
+			}
+			b.PublishTaskUpdate(ctx, taskID, string(models.TaskPermanentFail))
+		}
+	}
+
+	_, err := b.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, stream, StreamGroup, msg.ID)
+		pipe.XDel(ctx, stream, msg.ID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to drop dead-lettered entry %s after %d deliveries: %w", msg.ID, deliveries, err)
+	}
+	return nil
+}
+
+// Ack acknowledges the task's stream entry and deletes it from the stream.
+// If the consumer holds several deliveries of the task, the oldest one is
+// acked.
+func (b *StreamBroker) Ack(ctx context.Context, consumer, taskID string) error {
+	entry, ok := b.forget(inflightKey{consumer: consumer, taskID: taskID})
+	if !ok {
+		return nil
+	}
+	return b.ack(ctx, taskID, entry)
+}
+
+func (b *StreamBroker) ack(ctx context.Context, taskID string, entry streamEntry) error {
+	_, err := b.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, entry.stream, StreamGroup, entry.id)
+		pipe.XDel(ctx, entry.stream, entry.id)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to ack task %s: %w", taskID, err)
+	}
+	return nil
+}
+
+// RenewLease resets the idle time of the task's pending entries, provided
+// consumer still owns at least one of them.
+func (b *StreamBroker) RenewLease(ctx context.Context, consumer, taskID string) error {
+	b.mu.Lock()
+	entries := append([]streamEntry(nil), b.inflight[inflightKey{consumer: consumer, taskID: taskID}]...)
+	b.mu.Unlock()
+
+	held := false
+	for _, entry := range entries {
+		err := b.renew(ctx, consumer, taskID, entry)
+		if errors.Is(err, ErrLeaseLost) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		held = true
+	}
+	if !held {
+		return fmt.Errorf("task %s: %w", taskID, ErrLeaseLost)
+	}
+	return nil
+}
+
+// renew resets the idle time of one pending entry of the task, provided it
+// is still owned by consumer.
+func (b *StreamBroker) renew(ctx context.Context, consumer, taskID string, entry streamEntry) error {
+	pending, err := b.Client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: entry.stream,
+		Group:  StreamGroup,
+		Start:  entry.id,
+		End:    entry.id,
+		Count:  1,
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("failed to renew lease on %s: %w", taskID, err)
+	}
+	if len(pending) == 0 || pending[0].Consumer != consumer {
+		return fmt.Errorf("task %s: %w", taskID, ErrLeaseLost)
+	}
+
+	// XCLAIM with JUSTID resets idle time without bumping the delivery count.
+	err = b.Client.XClaimJustID(ctx, &redis.XClaimArgs{
+		Stream:   entry.stream,
+		Group:    StreamGroup,
+		Consumer: consumer,
+		Messages: []string{entry.id},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to renew lease on %s: %w", taskID, err)
+	}
+	return nil
+}
+
+func (b *StreamBroker) Heartbeat(ctx context.Context, consumer string, ttl time.Duration) error {
+	err := b.Client.Set(ctx, HeartbeatPrefix+consumer, time.Now().Unix(), ttl).Err()
+	if err != nil {
+		return fmt.Errorf("failed to write heartbeat: %w", err)
+	}
+	return nil
+}
+
+// ReapOrphans removes dead consumers that no longer own pending entries from
+// the group. Their abandoned entries need no reaping of their own: they are
+// reclaimed by FetchTask once idle for longer than the lease timeout, so the
+// returned count is always zero.
+func (b *StreamBroker) ReapOrphans(ctx context.Context) (int, error) {
+	levels, err := b.levels(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, level := range levels {
+		stream := level.stream
+		if err := b.ensureGroup(ctx, stream); err != nil {
+			return 0, err
+		}
+		consumers, err := b.Client.XInfoConsumers(ctx, stream, StreamGroup).Result()
+		if err != nil {
+			return 0, fmt.Errorf("failed to list consumers of %s: %w", stream, err)
+		}
+		for _, c := range consumers {
+			if c.Pending > 0 {
+				continue
+			}
+			alive, err := b.Client.Exists(ctx, HeartbeatPrefix+c.Name).Result()
+			if err != nil {
+				return 0, fmt.Errorf("failed to check heartbeat for %s: %w", c.Name, err)
+			}
+			if alive > 0 {
+				continue
+			}
+			if err := b.Client.XGroupDelConsumer(ctx, stream, StreamGroup, c.Name).Err(); err != nil {
+				return 0, fmt.Errorf("failed to remove consumer %s from %s: %w", c.Name, stream, err)
+			}
+		}
+	}
+	return 0, nil
+}
+
+// Remove deletes the task's entries from its stream, acking any that were
+// delivered, and drops it from the delayed set.
+func (b *StreamBroker) Remove(ctx context.Context, task *models.Task) error {
+	stream := streamFor(task.AgentType, task.Priority)
+	msgs, err := b.Client.XRange(ctx, stream, "-", "+").Result()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", stream, err)
+	}
+	var ids []string
+	for _, msg := range msgs {
+		if taskID, _ := msg.Values["task_id"].(string); taskID == task.ID {
+			ids = append(ids, msg.ID)
+		}
+	}
+
+	_, err = b.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(ids) > 0 {
+			pipe.XAck(ctx, stream, StreamGroup, ids...)
+			pipe.XDel(ctx, stream, ids...)
+		}
+		pipe.ZRem(ctx, StreamDelayed, delayedMember(task))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove task %s: %w", task.ID, err)
+	}
+	return nil
+}
+
+// QueueDepth counts the entries of each stream that have not been delivered
+// to a consumer yet: acked entries are deleted, so that is the stream length
+// less the group's pending entries.
+func (b *StreamBroker) QueueDepth(ctx context.Context, agentTypes ...string) (int, error) {
+	levels, err := b.levels(ctx, agentTypes)
+	if err != nil {
+		return 0, err
+	}
+
+	depth := 0
+	for _, level := range levels {
+		if err := b.ensureGroup(ctx, level.stream); err != nil {
+			return 0, err
+		}
+		length, err := b.Client.XLen(ctx, level.stream).Result()
+		if err != nil {
+			return 0, fmt.Errorf("failed to measure stream %s: %w", level.stream, err)
+		}
+		pending, err := b.Client.XPending(ctx, level.stream, StreamGroup).Result()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read pending entries of %s: %w", level.stream, err)
+		}
+		depth += int(max(length-pending.Count, 0))
+	}
+	return depth, nil
+}
+
+// Tracked scans the streams the given tasks would be on; entries stay in
+// their stream until they are acked, so this covers in-flight tasks too.
+func (b *StreamBroker) Tracked(ctx context.Context, tasks []*models.Task) (map[string]bool, error) {
+	tracked := make(map[string]bool, len(tasks))
+
+	streams := make(map[string]bool)
+	for _, task := range tasks {
+		streams[streamFor(task.AgentType, task.Priority)] = true
+	}
+	for stream := range streams {
+		msgs, err := b.Client.XRange(ctx, stream, "-", "+").Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", stream, err)
+		}
+		for _, msg := range msgs {
+			if taskID, _ := msg.Values["task_id"].(string); taskID != "" {
+				tracked[taskID] = true
+			}
+		}
+	}
+
+	dead, err := b.ListDLQ(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range dead {
+		tracked[entry.TaskID] = true
+	}
+
+	for _, task := range tasks {
+		if tracked[task.ID] {
+			continue
+		}
+		err := b.Client.ZScore(ctx, StreamDelayed, delayedMember(task)).Err()
+		if err == nil {
+			tracked[task.ID] = true
+		} else if !errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("failed to look up task %s: %w", task.ID, err)
+		}
+	}
+
+	// Only report on the tasks asked about.
+	result := make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		if tracked[task.ID] {
+			result[task.ID] = true
+		}
+	}
+	return result, nil
+}
+
+func (b *StreamBroker) AddToDLQ(ctx context.Context, taskID, reason string) error {
+	if _, err := b.RemoveFromDLQ(ctx, taskID); err != nil {
+		return err
+	}
+	err := b.Client.XAdd(ctx, &redis.XAddArgs{
+		Stream: StreamDeadLetter,
+		Values: map[string]interface{}{"task_id": taskID, "reason": reason},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to add to DLQ: %w", err)
+	}
+	return nil
+}
+
+// ListDLQ reads the whole dead-letter stream; the failure time is taken
+// from each entry ID.
+func (b *StreamBroker) ListDLQ(ctx context.Context) ([]DLQEntry, error) {
+	msgs, err := b.Client.XRange(ctx, StreamDeadLetter, "-", "+").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DLQ: %w", err)
+	}
+	entries := make([]DLQEntry, 0, len(msgs))
+	for _, msg := range msgs {
+		taskID, _ := msg.Values["task_id"].(string)
+		reason, _ := msg.Values["reason"].(string)
+		entries = append(entries, DLQEntry{TaskID: taskID, Reason: reason, FailedAt: entryTime(msg.ID)})
+	}
+	return entries, nil
+}
+
+// RemoveFromDLQ deletes every dead-letter entry for taskID.
+func (b *StreamBroker) RemoveFromDLQ(ctx context.Context, taskID string) (bool, error) {
+	msgs, err := b.Client.XRange(ctx, StreamDeadLetter, "-", "+").Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to read DLQ: %w", err)
+	}
+	var ids []string
+	for _, msg := range msgs {
+		if id, _ := msg.Values["task_id"].(string); id == taskID {
+			ids = append(ids, msg.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return false, nil
+	}
+	if err := b.Client.XDel(ctx, StreamDeadLetter, ids...).Err(); err != nil {
+		return false, fmt.Errorf("failed to remove %s from DLQ: %w", taskID, err)
+	}
+	return true, nil
+}
+
+func (b *StreamBroker) PurgeDLQ(ctx context.Context) (int, error) {
+	n, err := b.Client.XLen(ctx, StreamDeadLetter).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read DLQ: %w", err)
+	}
+	if err := b.Client.Del(ctx, StreamDeadLetter).Err(); err != nil {
+		return 0, fmt.Errorf("failed to purge DLQ: %w", err)
+	}
+	return int(n), nil
+}
+
+// ensureGroup creates the consumer group on stream once per broker.
+func (b *StreamBroker) ensureGroup(ctx context.Context, stream string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.groups[stream] {
+		return nil
+	}
+
+	err := b.Client.XGroupCreateMkStream(ctx, stream, StreamGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create consumer group on %s: %w", stream, err)
+	}
+	b.groups[stream] = true
+	return nil
+}
+
+// forget stops tracking the oldest delivery of a task and returns it.
+func (b *StreamBroker) forget(key inflightKey) (streamEntry, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entries := b.inflight[key]
+	if len(entries) == 0 {
+		return streamEntry{}, false
+	}
+	b.setInflightLocked(key, entries[1:])
+	return entries[0], true
+}
+
+// untrack stops tracking one delivery of a task.
+func (b *StreamBroker) untrack(key inflightKey, entry streamEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entries := b.inflight[key]
+	for i, e := range entries {
+		if e == entry {
+			b.setInflightLocked(key, append(entries[:i:i], entries[i+1:]...))
+			return
+		}
+	}
+}
+
+func (b *StreamBroker) setInflightLocked(key inflightKey, entries []streamEntry) {
+	if len(entries) == 0 {
+		delete(b.inflight, key)
+		return
+	}
+	b.inflight[key] = entries
+}
+
+// streamNotifyKey is the wakeup list of an agent type.
+func streamNotifyKey(agentType string) string {
+	return StreamNotifyPrefix + agentType
+}
+
+func streamFor(agentType string, priority int) string {
+	return StreamPrefix + agentType + ":p" + strconv.Itoa(priority)
+}
+
+// streamAgentType extracts the agent type from a stream key built by
+// streamFor.
+func streamAgentType(stream string) (string, bool) {
+	rest, ok := strings.CutPrefix(stream, StreamPrefix)
+	if !ok {
+		return "", false
+	}
+	sep := strings.LastIndex(rest, ":p")
+	if sep < 0 {
+		return "", false
+	}
+	return rest[:sep], true
+}
+
+// entryTime extracts the creation time embedded in a stream entry ID.
+func entryTime(id string) time.Time {
+	ms, err := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	if err != nil {
+		return time.Now()
+	}
+	return time.UnixMilli(ms)
+}
@@ -0,0 +1,86 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestStreamBroker(t *testing.T, store TaskStore) *StreamBroker {
+	t.Helper()
+	client := testRedisClient(t)
+	b := NewStreamBroker(client.Options().Addr, store, Options{})
+	b.Client.Close()
+	b.Client = client
+	return b
+}
+
+func pendingIDs(t *testing.T, b *StreamBroker, stream string) []string {
+	t.Helper()
+	pending, err := b.Client.XPendingExt(context.Background(), &redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  StreamGroup,
+		Start:  "-",
+		End:    "+",
+		Count:  10,
+	}).Result()
+	if err != nil {
+		t.Fatalf("XPENDING failed: %v", err)
+	}
+	ids := make([]string, len(pending))
+	for i, p := range pending {
+		ids[i] = p.ID
+	}
+	return ids
+}
+
+func TestStreamBrokerAcksOwnDelivery(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	b := newTestStreamBroker(t, store)
+	task := store.add("task", "QA", 2)
+	stream := streamFor(task.AgentType, task.Priority)
+
+	fetchCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := b.Enqueue(ctx, task); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	if _, err := b.FetchTask(fetchCtx, "node", "QA"); err != nil {
+		t.Fatalf("first FetchTask failed: %v", err)
+	}
+	first := pendingIDs(t, b, stream)
+
+	// Handed back and fetched by another slot of the same node before the
+	// first slot acks
+	if err := b.Enqueue(ctx, task); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	if _, err := b.FetchTask(fetchCtx, "node", "QA"); err != nil {
+		t.Fatalf("second FetchTask failed: %v", err)
+	}
+	both := pendingIDs(t, b, stream)
+	if len(first) != 1 || len(both) != 2 {
+		t.Fatalf("pending entries = %v then %v, want one then two", first, both)
+	}
+
+	if err := b.RenewLease(ctx, "node", task.ID); err != nil {
+		t.Errorf("RenewLease failed: %v", err)
+	}
+	if err := b.Ack(ctx, "node", task.ID); err != nil {
+		t.Fatalf("Ack failed: %v", err)
+	}
+	left := pendingIDs(t, b, stream)
+	if len(left) != 1 || left[0] == first[0] {
+		t.Errorf("pending after first ack = %v, want only the second delivery", left)
+	}
+
+	if err := b.Ack(ctx, "node", task.ID); err != nil {
+		t.Fatalf("Ack failed: %v", err)
+	}
+	if left := pendingIDs(t, b, stream); len(left) != 0 {
+		t.Errorf("pending after second ack = %v, want none", left)
+	}
+}
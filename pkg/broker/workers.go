@@ -0,0 +1,99 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// WorkerRegistryKey is a set of the IDs of every registered worker node.
+	WorkerRegistryKey = "agent_workers"
+	// WorkerInfoPrefix namespaces the expiring key holding each node's
+	// registry entry.
+	WorkerInfoPrefix = "agent_worker_info:"
+)
+
+func (e redisEvents) RegisterWorker(ctx context.Context, info *models.WorkerInfo, ttl time.Duration) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to marshal worker info: %w", err)
+	}
+	_, err = e.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, WorkerInfoPrefix+info.ID, data, ttl)
+		pipe.SAdd(ctx, WorkerRegistryKey, info.ID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register worker: %w", err)
+	}
+	return nil
+}
+
+func (e redisEvents) DeregisterWorker(ctx context.Context, id string) error {
+	_, err := e.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, WorkerInfoPrefix+id)
+		pipe.SRem(ctx, WorkerRegistryKey, id)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to deregister worker: %w", err)
+	}
+	return nil
+}
+
+// ListWorkers returns the registered nodes whose entry has not expired,
+// pruning the IDs of those that have from the registry set.
+func (e redisEvents) ListWorkers(ctx context.Context) ([]models.WorkerInfo, error) {
+	ids, err := e.Client.SMembers(ctx, WorkerRegistryKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workers: %w", err)
+	}
+	workers := []models.WorkerInfo{}
+	if len(ids) == 0 {
+		return workers, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = WorkerInfoPrefix + id
+	}
+	values, err := e.Client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load workers: %w", err)
+	}
+
+	var expired []interface{}
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			expired = append(expired, ids[i])
+			continue
+		}
+		var info models.WorkerInfo
+		if err := json.Unmarshal([]byte(data), &info); err != nil {
+			return nil, fmt.Errorf("failed to decode worker %s: %w", ids[i], err)
+		}
+		workers = append(workers, info)
+	}
+	if len(expired) > 0 {
+		if err := e.Client.SRem(ctx, WorkerRegistryKey, expired...).Err(); err != nil {
+			return nil, fmt.Errorf("failed to prune workers: %w", err)
+		}
+	}
+
+	sortWorkers(workers)
+	return workers, nil
+}
+
+// sortWorkers orders workers by ID.
+func sortWorkers(workers []models.WorkerInfo) {
+	sort.Slice(workers, func(i, j int) bool {
+		return workers[i].ID < workers[j].ID
+	})
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+)
+
+// insertOutbox records that a task still has to be handed to the broker. It
+// must run in the transaction that stores or changes the task.
+func insertOutbox(ctx context.Context, conn execer, taskID string) error {
+	query := `
+		INSERT INTO task_outbox (task_id, created_at)
+		VALUES ($1, $2)
+	`
+	if _, err := conn.Exec(ctx, query, taskID, time.Now()); err != nil {
+		return fmt.Errorf("failed to insert outbox entry: %w", err)
+	}
+	return nil
+}
+
+// RelayOutbox hands up to limit unsent outbox entries, oldest first, to
+// publish and marks the ones it accepted as sent. Entries are locked while
+// they are relayed, so several relays can run side by side without
+// publishing the same entry twice; an entry whose publish fails stays unsent
+// and is retried by a later call. It returns how many entries were sent and
+// how many were looked at.
+func (db *DB) RelayOutbox(ctx context.Context, limit int, publish func(*models.Task) error) (sent, seen int, err error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		SELECT o.id, ` + prefixedTaskColumns + `
+		FROM task_outbox o
+		JOIN tasks t ON t.id = o.task_id
+		WHERE o.sent_at IS NULL
+		ORDER BY o.id
+		LIMIT $1
+		FOR UPDATE OF o SKIP LOCKED
+	`
+	rows, err := tx.Query(ctx, query, limit)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to query outbox: %w", err)
+	}
+	type entry struct {
+		id   int64
+		task *models.Task
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		e.task, err = scanTask(rows, &e.id)
+		if err != nil {
+			rows.Close()
+			return 0, 0, fmt.Errorf("failed to scan outbox entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("failed to read outbox: %w", err)
+	}
+
+	for _, e := range entries {
+		if pubErr := publish(e.task); pubErr != nil {
+			_, err := tx.Exec(ctx, `UPDATE task_outbox SET attempts = attempts + 1, last_error = $1 WHERE id = $2`, pubErr.Error(), e.id)
+			if err != nil {
+				return 0, 0, fmt.Errorf("failed to record outbox failure: %w", err)
+			}
+			continue
+		}
+		_, err := tx.Exec(ctx, `UPDATE task_outbox SET attempts = attempts + 1, last_error = NULL, sent_at = $1 WHERE id = $2`, time.Now(), e.id)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to mark outbox entry sent: %w", err)
+		}
+		sent++
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit outbox: %w", err)
+	}
+	return sent, len(entries), nil
+}
+
+// PruneOutbox deletes entries that were sent more than olderThan ago.
+func (db *DB) PruneOutbox(ctx context.Context, olderThan time.Duration) (int64, error) {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM task_outbox WHERE sent_at < $1`, time.Now().Add(-olderThan))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune outbox: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
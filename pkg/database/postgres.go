@@ -2,13 +2,48 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/YehiaGewily/Agent-Mesh/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+var (
+	// ErrTaskNotFound is returned when no task has the requested ID.
+	ErrTaskNotFound = errors.New("task not found")
+	// ErrTaskConflict is returned when a task is not in a status that
+	// allows the requested change.
+	ErrTaskConflict = errors.New("task status does not allow this operation")
+)
+
+// TaskFilter narrows ListTasks. Zero fields do not filter. After/AfterID is
+// the keyset cursor: only tasks that sort after that (created_at, id) pair
+// are returned.
+type TaskFilter struct {
+	Status        models.TaskStatus
+	AgentType     string
+	Priority      *int
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+
+	After   time.Time
+	AfterID string
+	Limit   int
+}
+
+// taskColumns lists the columns scanTask expects, in order.
+const taskColumns = `id, status, priority, agent_type, payload, retry_count, created_at, updated_at,
+	result, result_text, worker_id, started_at, finished_at, timeout_seconds, last_error, last_error_class, retry_policy`
+
+// prefixedTaskColumns is taskColumns qualified with the alias "t", for joins.
+const prefixedTaskColumns = `t.id, t.status, t.priority, t.agent_type, t.payload, t.retry_count, t.created_at, t.updated_at,
+	t.result, t.result_text, t.worker_id, t.started_at, t.finished_at, t.timeout_seconds, t.last_error, t.last_error_class, t.retry_policy`
+
 type DB struct {
 	Pool *pgxpool.Pool
 }
@@ -35,12 +70,38 @@ func (db *DB) Close() {
 	db.Pool.Close()
 }
 
+// StoreTask stores a new task together with the outbox entry that gets it
+// onto the broker, so a stored task is always eventually queued.
 func (db *DB) StoreTask(ctx context.Context, task *models.Task) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := insertTask(ctx, tx, task); err != nil {
+		return err
+	}
+	if err := insertOutbox(ctx, tx, task.ID); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit task: %w", err)
+	}
+	return nil
+}
+
+// execer is satisfied by both the pool and a transaction.
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+func insertTask(ctx context.Context, conn execer, task *models.Task) error {
 	query := `
-		INSERT INTO tasks (id, status, priority, agent_type, payload, retry_count, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		INSERT INTO tasks (id, status, priority, agent_type, payload, retry_count, created_at, updated_at, timeout_seconds, retry_policy)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 	`
-	_, err := db.Pool.Exec(ctx, query,
+	_, err := conn.Exec(ctx, query,
 		task.ID,
 		task.Status,
 		task.Priority,
@@ -49,6 +110,8 @@ func (db *DB) StoreTask(ctx context.Context, task *models.Task) error {
 		task.RetryCount,
 		task.CreatedAt,
 		task.UpdatedAt,
+		task.TimeoutSeconds,
+		task.RetryPolicy,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to insert task: %w", err)
@@ -56,6 +119,59 @@ func (db *DB) StoreTask(ctx context.Context, task *models.Task) error {
 	return nil
 }
 
+// StoreTaskWithKey stores task, like StoreTask, unless idempotencyKey was used within window,
+// in which case the task stored under that key is returned instead. created
+// reports whether task itself was stored.
+func (db *DB) StoreTaskWithKey(ctx context.Context, task *models.Task, idempotencyKey string, window time.Duration) (stored *models.Task, created bool, err error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := insertTask(ctx, tx, task); err != nil {
+		return nil, false, err
+	}
+	if err := insertOutbox(ctx, tx, task.ID); err != nil {
+		return nil, false, err
+	}
+
+	// Claim the key, taking it over only if its previous use has expired.
+	// A concurrent claim blocks here until the other transaction finishes.
+	query := `
+		INSERT INTO task_idempotency_keys (idempotency_key, task_id, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (idempotency_key) DO UPDATE
+		SET task_id = EXCLUDED.task_id, created_at = EXCLUDED.created_at
+		WHERE task_idempotency_keys.created_at < $4
+	`
+	now := time.Now()
+	tag, err := tx.Exec(ctx, query, idempotencyKey, task.ID, now, now.Add(-window))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to store idempotency key: %w", err)
+	}
+	if tag.RowsAffected() == 1 {
+		if err := tx.Commit(ctx); err != nil {
+			return nil, false, fmt.Errorf("failed to commit task: %w", err)
+		}
+		return task, true, nil
+	}
+
+	// The key is still live; drop our insert and hand back the original.
+	tx.Rollback(ctx)
+	query = `
+		SELECT ` + prefixedTaskColumns + `
+		FROM task_idempotency_keys k
+		JOIN tasks t ON t.id = k.task_id
+		WHERE k.idempotency_key = $1
+	`
+	stored, err = scanTask(db.Pool.QueryRow(ctx, query, idempotencyKey))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load task for idempotency key: %w", err)
+	}
+	return stored, false, nil
+}
+
 func (db *DB) UpdateTaskStatus(ctx context.Context, taskID string, status models.TaskStatus) error {
 	query := `
 		UPDATE tasks 
@@ -69,42 +185,361 @@ func (db *DB) UpdateTaskStatus(ctx context.Context, taskID string, status models
 	return nil
 }
 
+// ClaimTask marks a fetched task as running. Tasks that already finished,
+// failed permanently or were cancelled are left alone and ClaimTask reports
+// false, so stale or duplicate deliveries are not run again.
+func (db *DB) ClaimTask(ctx context.Context, taskID string) (bool, error) {
+	query := `
+		UPDATE tasks
+		SET status = $1, updated_at = $2
+		WHERE id = $3 AND status NOT IN ($4, $5, $6)
+	`
+	tag, err := db.Pool.Exec(ctx, query, models.TaskStatusRunning, time.Now(), taskID,
+		models.TaskStatusCompleted, models.TaskPermanentFail, models.TaskStatusCancelled)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim task: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ListTasks returns tasks matching filter, newest first.
+func (db *DB) ListTasks(ctx context.Context, filter TaskFilter) ([]*models.Task, error) {
+	var (
+		conds []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if filter.Status != "" {
+		conds = append(conds, "status = "+arg(filter.Status))
+	}
+	if filter.AgentType != "" {
+		conds = append(conds, "agent_type = "+arg(filter.AgentType))
+	}
+	if filter.Priority != nil {
+		conds = append(conds, "priority = "+arg(*filter.Priority))
+	}
+	if !filter.CreatedAfter.IsZero() {
+		conds = append(conds, "created_at >= "+arg(filter.CreatedAfter))
+	}
+	if !filter.CreatedBefore.IsZero() {
+		conds = append(conds, "created_at < "+arg(filter.CreatedBefore))
+	}
+	if filter.AfterID != "" {
+		conds = append(conds, fmt.Sprintf("(created_at, id) < (%s, %s)", arg(filter.After), arg(filter.AfterID)))
+	}
+
+	query := `SELECT ` + taskColumns + ` FROM tasks`
+	if len(conds) > 0 {
+		query += ` WHERE ` + strings.Join(conds, " AND ")
+	}
+	query += ` ORDER BY created_at DESC, id DESC LIMIT ` + arg(filter.Limit)
+
+	rows, err := db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tasks: %w", err)
+	}
+	defer rows.Close()
+
+	tasks := []*models.Task{}
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan task: %w", err)
+		}
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tasks: %w", err)
+	}
+	return tasks, nil
+}
+
+// TransitionTask moves a task from one status to another, but only if it is
+// still in from. It reports whether the task was moved.
+func (db *DB) TransitionTask(ctx context.Context, taskID string, from, to models.TaskStatus) (bool, error) {
+	query := `
+		UPDATE tasks
+		SET status = $1, updated_at = $2
+		WHERE id = $3 AND status = $4
+	`
+	tag, err := db.Pool.Exec(ctx, query, to, time.Now(), taskID, from)
+	if err != nil {
+		return false, fmt.Errorf("failed to transition task: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// StartTask records that workerID began running a task that is currently
+// running, clearing the outcome of any earlier run.
+func (db *DB) StartTask(ctx context.Context, taskID, workerID string) error {
+	query := `
+		UPDATE tasks
+		SET worker_id = $1, started_at = $2, finished_at = NULL, result = NULL, result_text = NULL
+		WHERE id = $3 AND status = $4
+	`
+	_, err := db.Pool.Exec(ctx, query, workerID, time.Now(), taskID, models.TaskStatusRunning)
+	if err != nil {
+		return fmt.Errorf("failed to start task: %w", err)
+	}
+	return nil
+}
+
+// FinishTask moves a running task to a final status and stores its result,
+// which may be nil. Like TransitionTask it reports whether the task was
+// still running.
+func (db *DB) FinishTask(ctx context.Context, taskID string, status models.TaskStatus, result map[string]interface{}, resultText string) (bool, error) {
+	query := `
+		UPDATE tasks
+		SET status = $1, result = $2, result_text = NULLIF($3, ''), finished_at = $4, updated_at = $4
+		WHERE id = $5 AND status = $6
+	`
+	tag, err := db.Pool.Exec(ctx, query, status, result, resultText, time.Now(), taskID, models.TaskStatusRunning)
+	if err != nil {
+		return false, fmt.Errorf("failed to finish task: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// FindStaleTasks returns up to limit tasks in status that have not changed
+// since before, oldest first. Tasks still waiting in the outbox are left out,
+// since the relay is responsible for them.
+func (db *DB) FindStaleTasks(ctx context.Context, status models.TaskStatus, before time.Time, limit int) ([]*models.Task, error) {
+	query := `
+		SELECT ` + prefixedTaskColumns + `
+		FROM tasks t
+		WHERE t.status = $1 AND t.updated_at < $2
+		  AND NOT EXISTS (
+			SELECT 1 FROM task_outbox o
+			WHERE o.task_id = t.id AND o.sent_at IS NULL
+		  )
+		ORDER BY t.updated_at
+		LIMIT $3
+	`
+	rows, err := db.Pool.Query(ctx, query, status, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stale tasks: %w", err)
+	}
+	defer rows.Close()
+
+	var tasks []*models.Task
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan task: %w", err)
+		}
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read stale tasks: %w", err)
+	}
+	return tasks, nil
+}
+
 func (db *DB) GetTask(ctx context.Context, taskID string) (*models.Task, error) {
 	query := `
-		SELECT id, status, priority, agent_type, payload, retry_count, created_at, updated_at
+		SELECT ` + taskColumns + `
 		FROM tasks
 		WHERE id = $1
 	`
-	row := db.Pool.QueryRow(ctx, query, taskID)
-
-	var task models.Task
-	err := row.Scan(
-		&task.ID,
-		&task.Status,
-		&task.Priority,
-		&task.AgentType,
-		&task.Payload,
-		&task.RetryCount,
-		&task.CreatedAt,
-		&task.UpdatedAt,
-	)
+	task, err := scanTask(db.Pool.QueryRow(ctx, query, taskID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrTaskNotFound
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to scan task: %w", err)
 	}
-	return &task, nil
+	return task, nil
+}
+
+// GetTasks loads the tasks with the given IDs, keyed by ID. IDs without a
+// task are left out.
+func (db *DB) GetTasks(ctx context.Context, taskIDs []string) (map[string]*models.Task, error) {
+	query := `
+		SELECT ` + taskColumns + `
+		FROM tasks
+		WHERE id = ANY($1)
+	`
+	rows, err := db.Pool.Query(ctx, query, taskIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tasks: %w", err)
+	}
+	defer rows.Close()
+
+	tasks := make(map[string]*models.Task, len(taskIDs))
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan task: %w", err)
+		}
+		tasks[task.ID] = task
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tasks: %w", err)
+	}
+	return tasks, nil
+}
+
+// ResetTask returns a task to pending with a fresh retry budget, e.g. when
+// it is replayed from the dead-letter queue. If any from statuses are given
+// the task is only reset while in one of them; otherwise ErrTaskConflict is
+// returned. An outbox entry is written in the same transaction, so the relay
+// puts the task back on its queue.
+func (db *DB) ResetTask(ctx context.Context, taskID string, from ...models.TaskStatus) (*models.Task, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		UPDATE tasks
+		SET status = $1, retry_count = 0, updated_at = $2,
+			result = NULL, result_text = NULL, finished_at = NULL
+		WHERE id = $3 AND (cardinality($4::text[]) = 0 OR status = ANY($4))
+		RETURNING ` + taskColumns
+	statuses := make([]string, len(from))
+	for i, status := range from {
+		statuses[i] = string(status)
+	}
+	task, err := scanTask(tx.QueryRow(ctx, query, models.TaskStatusPending, time.Now(), taskID, statuses))
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, err := db.GetTask(ctx, taskID); err != nil {
+			return nil, err
+		}
+		return nil, ErrTaskConflict
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to reset task: %w", err)
+	}
+	if err := insertOutbox(ctx, tx, task.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit task reset: %w", err)
+	}
+	return task, nil
 }
 
-func (db *DB) IncrementRetryCount(ctx context.Context, taskID string) (int, error) {
+// RecordFailure stores the error of a failed attempt on the task and bumps
+// its retry count, returning the new count.
+func (db *DB) RecordFailure(ctx context.Context, taskID, errMsg, errClass string) (int, error) {
 	query := `
 		UPDATE tasks
-		SET retry_count = retry_count + 1, updated_at = $1
-		WHERE id = $2
+		SET retry_count = retry_count + 1, last_error = $1, last_error_class = $2, updated_at = $3
+		WHERE id = $4
 		RETURNING retry_count
 	`
 	var newCount int
-	err := db.Pool.QueryRow(ctx, query, time.Now(), taskID).Scan(&newCount)
+	err := db.Pool.QueryRow(ctx, query, errMsg, errClass, time.Now(), taskID).Scan(&newCount)
 	if err != nil {
-		return 0, fmt.Errorf("failed to increment retry count: %w", err)
+		return 0, fmt.Errorf("failed to record failure: %w", err)
 	}
 	return newCount, nil
 }
+
+// StartAttempt records that workerID began executing a task and returns the
+// attempt number, counting every attempt the task has ever had.
+func (db *DB) StartAttempt(ctx context.Context, taskID, workerID string) (int, error) {
+	query := `
+		INSERT INTO task_attempts (task_id, attempt, worker_id, status, started_at)
+		SELECT $1, COALESCE(MAX(attempt), 0) + 1, $2, $3, $4
+		FROM task_attempts
+		WHERE task_id = $1
+		RETURNING attempt
+	`
+	var attempt int
+	err := db.Pool.QueryRow(ctx, query, taskID, workerID, models.TaskStatusRunning, time.Now()).Scan(&attempt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start attempt: %w", err)
+	}
+	return attempt, nil
+}
+
+// FinishAttempt records the outcome of an attempt. errMsg and errClass are
+// empty for successful attempts.
+func (db *DB) FinishAttempt(ctx context.Context, taskID string, attempt int, status models.TaskStatus, errMsg, errClass string) error {
+	query := `
+		UPDATE task_attempts
+		SET status = $1, error_message = NULLIF($2, ''), error_class = NULLIF($3, ''), finished_at = $4
+		WHERE task_id = $5 AND attempt = $6
+	`
+	_, err := db.Pool.Exec(ctx, query, status, errMsg, errClass, time.Now(), taskID, attempt)
+	if err != nil {
+		return fmt.Errorf("failed to finish attempt: %w", err)
+	}
+	return nil
+}
+
+// GetTaskAttempts returns a task's attempts, oldest first.
+func (db *DB) GetTaskAttempts(ctx context.Context, taskID string) ([]models.TaskAttempt, error) {
+	query := `
+		SELECT task_id, attempt, worker_id, status, COALESCE(error_message, ''), COALESCE(error_class, ''), started_at, finished_at
+		FROM task_attempts
+		WHERE task_id = $1
+		ORDER BY attempt
+	`
+	rows, err := db.Pool.Query(ctx, query, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attempts: %w", err)
+	}
+	defer rows.Close()
+
+	attempts := []models.TaskAttempt{}
+	for rows.Next() {
+		var a models.TaskAttempt
+		err := rows.Scan(&a.TaskID, &a.Attempt, &a.WorkerID, &a.Status, &a.ErrorMessage, &a.ErrorClass, &a.StartedAt, &a.FinishedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan attempt: %w", err)
+		}
+		attempts = append(attempts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read attempts: %w", err)
+	}
+	return attempts, nil
+}
+
+// scanTask reads a row selected with taskColumns, preceded by any leading
+// columns, which are scanned into leading.
+func scanTask(row pgx.Row, leading ...any) (*models.Task, error) {
+	var task models.Task
+	var resultText, workerID, lastError, lastErrorClass *string
+	err := row.Scan(append(leading,
+		&task.ID,
+		&task.Status,
+		&task.Priority,
+		&task.AgentType,
+		&task.Payload,
+		&task.RetryCount,
+		&task.CreatedAt,
+		&task.UpdatedAt,
+		&task.Result,
+		&resultText,
+		&workerID,
+		&task.StartedAt,
+		&task.FinishedAt,
+		&task.TimeoutSeconds,
+		&lastError,
+		&lastErrorClass,
+		&task.RetryPolicy,
+	)...)
+	if err != nil {
+		return nil, err
+	}
+	if resultText != nil {
+		task.ResultText = *resultText
+	}
+	if workerID != nil {
+		task.WorkerID = *workerID
+	}
+	if lastError != nil {
+		task.LastError = *lastError
+	}
+	if lastErrorClass != nil {
+		task.LastErrorClass = *lastErrorClass
+	}
+	return &task, nil
+}
@@ -0,0 +1,67 @@
+// Package llm is a small client abstraction over chat-completion style
+// language model APIs.
+package llm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Roles used in chat messages.
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ChatRequest is a provider-neutral chat completion request. Zero fields fall
+// back to the provider's defaults.
+type ChatRequest struct {
+	Model       string
+	Messages    []Message
+	Temperature *float64
+	MaxTokens   int
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type ChatResponse struct {
+	Model        string
+	Content      string
+	FinishReason string
+	Usage        Usage
+}
+
+// Provider sends chat completion requests to a model.
+type Provider interface {
+	Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error)
+}
+
+// APIError is a non-successful response from a provider.
+type APIError struct {
+	StatusCode int
+	Message    string
+	// RetryAfter is the wait the provider asked for, if any.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("llm api error (status %d): %s", e.StatusCode, e.Message)
+}
+
+// retryable reports whether a request that failed with status may succeed
+// if sent again.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
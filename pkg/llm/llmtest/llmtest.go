@@ -0,0 +1,128 @@
+// Package llmtest provides a fake OpenAI-compatible chat-completions server
+// so code using pkg/llm can be exercised without network access or API keys.
+package llmtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/YehiaGewily/Agent-Mesh/pkg/llm"
+)
+
+// Failure is a canned error response, e.g. a 429 with a Retry-After header.
+type Failure struct {
+	Status int
+	// RetryAfter is sent verbatim as the Retry-After header if set.
+	RetryAfter string
+}
+
+// Handler answers POST /chat/completions (under any prefix) with a
+// deterministic completion that echoes the last user message. Queued
+// failures are returned first, one per request.
+type Handler struct {
+	mu       sync.Mutex
+	failures []Failure
+	requests []llm.ChatRequest
+}
+
+func NewHandler() *Handler {
+	return &Handler{}
+}
+
+// FailNext queues failures to be returned by the next requests, in order.
+func (h *Handler) FailNext(failures ...Failure) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures = append(h.failures, failures...)
+}
+
+// Requests returns every request received so far, including failed ones.
+func (h *Handler) Requests() []llm.ChatRequest {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]llm.ChatRequest(nil), h.requests...)
+}
+
+type chatRequest struct {
+	Model    string        `json:"model"`
+	Messages []llm.Message `json:"messages"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/chat/completions") {
+		writeError(w, http.StatusNotFound, "unknown endpoint")
+		return
+	}
+	var req chatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	h.mu.Lock()
+	h.requests = append(h.requests, llm.ChatRequest{Model: req.Model, Messages: req.Messages})
+	var failure *Failure
+	if len(h.failures) > 0 {
+		failure = &h.failures[0]
+		h.failures = h.failures[1:]
+	}
+	h.mu.Unlock()
+
+	if failure != nil {
+		if failure.RetryAfter != "" {
+			w.Header().Set("Retry-After", failure.RetryAfter)
+		}
+		writeError(w, failure.Status, http.StatusText(failure.Status))
+		return
+	}
+
+	prompt := ""
+	for _, msg := range req.Messages {
+		if msg.Role == llm.RoleUser {
+			prompt = msg.Content
+		}
+	}
+	content := fmt.Sprintf("mock completion for: %s", prompt)
+	promptTokens, completionTokens := len(strings.Fields(prompt)), len(strings.Fields(content))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object": "chat.completion",
+		"model":  req.Model,
+		"choices": []map[string]interface{}{{
+			"index":         0,
+			"message":       llm.Message{Role: llm.RoleAssistant, Content: content},
+			"finish_reason": "stop",
+		}},
+		"usage": llm.Usage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		},
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"message": msg},
+	})
+}
+
+// Server is a Handler listening on a local port.
+type Server struct {
+	*Handler
+	*httptest.Server
+}
+
+// NewServer starts a mock server; use URL as the client's base URL and Close
+// it when done.
+func NewServer() *Server {
+	h := NewHandler()
+	return &Server{Handler: h, Server: httptest.NewServer(h)}
+}
@@ -0,0 +1,188 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMaxRetries is how often a rate-limited or failed request is
+	// retried before giving up.
+	DefaultMaxRetries = 3
+	// defaultBackoff is the first retry delay when the server does not send
+	// Retry-After; it doubles on every retry.
+	defaultBackoff = 500 * time.Millisecond
+	// maxRetryAfter caps how long a Retry-After header can make us wait.
+	maxRetryAfter = 60 * time.Second
+)
+
+// OpenAIConfig configures an OpenAIClient.
+type OpenAIConfig struct {
+	// BaseURL is the API root, e.g. "https://api.openai.com/v1". Any
+	// OpenAI-compatible server works.
+	BaseURL string
+	APIKey  string
+	// Model is used for requests that do not name one.
+	Model      string
+	MaxRetries int
+	// Timeout bounds each HTTP request. Zero means no timeout beyond the
+	// request context.
+	Timeout time.Duration
+}
+
+// OpenAIClient is a Provider for OpenAI-compatible chat-completions APIs.
+type OpenAIClient struct {
+	baseURL    string
+	apiKey     string
+	model      string
+	maxRetries int
+	http       *http.Client
+}
+
+func NewOpenAIClient(cfg OpenAIConfig) *OpenAIClient {
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	return &OpenAIClient{
+		baseURL:    strings.TrimRight(cfg.BaseURL, "/"),
+		apiKey:     cfg.APIKey,
+		model:      cfg.Model,
+		maxRetries: cfg.MaxRetries,
+		http:       &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+type openAIRequest struct {
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	MaxTokens   int       `json:"max_tokens,omitempty"`
+}
+
+type openAIResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message      Message `json:"message"`
+		FinishReason string  `json:"finish_reason"`
+	} `json:"choices"`
+	Usage Usage `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Chat sends req to the chat-completions endpoint. Rate-limited (429) and
+// server-error responses are retried up to MaxRetries times, waiting as long
+// as the server's Retry-After header asks, or with exponential backoff if it
+// sends none.
+func (c *OpenAIClient) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	model := req.Model
+	if model == "" {
+		model = c.model
+	}
+	body, err := json.Marshal(openAIRequest{
+		Model:       model,
+		Messages:    req.Messages,
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal chat request: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, retryAfter, err := c.send(ctx, body)
+		if err == nil {
+			return resp, nil
+		}
+		apiErr, ok := err.(*APIError)
+		if !ok || !retryable(apiErr.StatusCode) || attempt >= c.maxRetries {
+			return nil, err
+		}
+
+		wait := retryAfter
+		if wait <= 0 {
+			wait = time.Duration(float64(defaultBackoff) * math.Pow(2, float64(attempt)))
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("chat request aborted while backing off: %w", ctx.Err())
+		}
+	}
+}
+
+// send makes a single request. For API errors it also returns how long the
+// server asked us to wait before retrying, if it said.
+func (c *OpenAIClient) send(ctx context.Context, body []byte) (*ChatResponse, time.Duration, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to build chat request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	httpResp, err := c.http.Do(httpReq)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to send chat request: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read chat response: %w", err)
+	}
+
+	var parsed openAIResponse
+	jsonErr := json.Unmarshal(data, &parsed)
+
+	if httpResp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(data))
+		if jsonErr == nil && parsed.Error != nil {
+			msg = parsed.Error.Message
+		}
+		retryAfter := parseRetryAfter(httpResp.Header.Get("Retry-After"))
+		return nil, retryAfter, &APIError{StatusCode: httpResp.StatusCode, Message: msg, RetryAfter: retryAfter}
+	}
+	if jsonErr != nil {
+		return nil, 0, fmt.Errorf("failed to decode chat response: %w", jsonErr)
+	}
+	if len(parsed.Choices) == 0 {
+		return nil, 0, fmt.Errorf("chat response has no choices")
+	}
+
+	return &ChatResponse{
+		Model:        parsed.Model,
+		Content:      parsed.Choices[0].Message.Content,
+		FinishReason: parsed.Choices[0].FinishReason,
+		Usage:        parsed.Usage,
+	}, 0, nil
+}
+
+// parseRetryAfter understands both forms of the Retry-After header, delay
+// seconds and an HTTP date, and caps the result at maxRetryAfter.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	var wait time.Duration
+	if secs, err := strconv.Atoi(value); err == nil {
+		wait = time.Duration(secs) * time.Second
+	} else if at, err := http.ParseTime(value); err == nil {
+		wait = time.Until(at)
+	}
+	if wait < 0 {
+		return 0
+	}
+	return min(wait, maxRetryAfter)
+}
@@ -0,0 +1,92 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/YehiaGewily/Agent-Mesh/pkg/llm"
+	"github.com/YehiaGewily/Agent-Mesh/pkg/llm/llmtest"
+)
+
+func chat(t *testing.T, server *llmtest.Server, maxRetries int) (*llm.ChatResponse, error) {
+	t.Helper()
+	client := llm.NewOpenAIClient(llm.OpenAIConfig{
+		BaseURL:    server.URL,
+		Model:      "test-model",
+		MaxRetries: maxRetries,
+	})
+	return client.Chat(context.Background(), llm.ChatRequest{
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: "hello"}},
+	})
+}
+
+func TestChatRetriesRateLimitsAndServerErrors(t *testing.T) {
+	server := llmtest.NewServer()
+	defer server.Close()
+	server.FailNext(
+		llmtest.Failure{Status: http.StatusTooManyRequests, RetryAfter: "1"},
+		llmtest.Failure{Status: http.StatusInternalServerError},
+	)
+
+	start := time.Now()
+	resp, err := chat(t, server, 2)
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if resp.Content != "mock completion for: hello" {
+		t.Errorf("unexpected content %q", resp.Content)
+	}
+	if n := len(server.Requests()); n != 3 {
+		t.Errorf("got %d requests, want 3", n)
+	}
+	// 1s from Retry-After, then the second backoff step of 1s
+	if elapsed < 2*time.Second {
+		t.Errorf("retries took %v, want at least 2s", elapsed)
+	}
+}
+
+func TestChatGivesUpAfterMaxRetries(t *testing.T) {
+	server := llmtest.NewServer()
+	defer server.Close()
+	server.FailNext(
+		llmtest.Failure{Status: http.StatusInternalServerError},
+		llmtest.Failure{Status: http.StatusBadGateway},
+		llmtest.Failure{Status: http.StatusServiceUnavailable},
+	)
+
+	start := time.Now()
+	_, err := chat(t, server, 1)
+	elapsed := time.Since(start)
+	var apiErr *llm.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got error %v, want an APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("got status %d, want %d", apiErr.StatusCode, http.StatusBadGateway)
+	}
+	if n := len(server.Requests()); n != 2 {
+		t.Errorf("got %d requests, want 2", n)
+	}
+	if elapsed < 500*time.Millisecond {
+		t.Errorf("retry took %v, want at least the 500ms backoff", elapsed)
+	}
+}
+
+func TestChatDoesNotRetryClientErrors(t *testing.T) {
+	server := llmtest.NewServer()
+	defer server.Close()
+	server.FailNext(llmtest.Failure{Status: http.StatusBadRequest})
+
+	_, err := chat(t, server, 3)
+	var apiErr *llm.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("got error %v, want a 400 APIError", err)
+	}
+	if n := len(server.Requests()); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
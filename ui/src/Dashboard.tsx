@@ -5,7 +5,7 @@ import { SystemHealth } from './components/SystemHealth';
 
 const Dashboard: React.FC = () => {
     const [tasks, setTasks] = useState<Task[]>([]);
-    const [workerHealth, setWorkerHealth] = useState<Record<number, SystemHealthMetric>>({});
+    const [workerHealth, setWorkerHealth] = useState<Record<string, SystemHealthMetric>>({});
     const [isConnected, setIsConnected] = useState(false);
     const [highTrafficMode, setHighTrafficMode] = useState(false);
 
@@ -60,7 +60,7 @@ const Dashboard: React.FC = () => {
 
             setTasks(prevTasks => {
                 let newTasks = [...prevTasks];
-                let healthUpdates: Record<number, SystemHealthMetric> = {};
+                let healthUpdates: Record<string, SystemHealthMetric> = {};
                 let hasHealthUpdates = false;
 
                 messages.forEach(data => {
@@ -72,6 +72,11 @@ const Dashboard: React.FC = () => {
                         return;
                     }
 
+                    // Scaling decisions show up in the next health metric
+                    if (data.type === "SCALING_EVENT") {
+                        return;
+                    }
+
                     // Handle Tasks
                     let updatedTask: Task;
                     if (data.id && data.status && data.agent_type) {
@@ -5,16 +5,16 @@ import type { SystemHealthMetric } from '../types';
 import { clsx } from 'clsx';
 
 interface SystemHealthProps {
-    healthData: Record<number, SystemHealthMetric>;
+    healthData: Record<string, SystemHealthMetric>;
 }
 
 export const SystemHealth: React.FC<SystemHealthProps> = ({ healthData }) => {
     // track last update time for heartbeat effect per worker
-    const [lastUpdates, setLastUpdates] = useState<Record<number, number>>({});
+    const [lastUpdates, setLastUpdates] = useState<Record<string, number>>({});
 
     useEffect(() => {
         const now = Date.now();
-        const updates: Record<number, number> = {};
+        const updates: Record<string, number> = {};
         Object.values(healthData).forEach(h => {
             updates[h.worker_id] = now;
         });
@@ -55,10 +55,18 @@ export const SystemHealth: React.FC<SystemHealthProps> = ({ healthData }) => {
                             <div className="flex justify-between items-center mb-4">
                                 <div className="flex items-center gap-2">
                                     {/* Use lastUpdates to trigger re-animation if we wanted, for now just constant pulse */}
-                                    <div className="w-2 h-2 rounded-full bg-green-500 animate-pulse" />
+                                    <div className={clsx("w-2 h-2 rounded-full animate-pulse", metric.paused ? "bg-yellow-500" : "bg-green-500")} />
                                     <span className="text-xs font-mono font-bold text-gray-300">
                                         WORKER-{metric.worker_id}
                                     </span>
+                                    {metric.concurrency !== undefined && (
+                                        <span className="text-[10px] font-mono text-gray-500">
+                                            {metric.running ?? 0}/{metric.concurrency} SLOTS
+                                        </span>
+                                    )}
+                                    {metric.paused && (
+                                        <span className="text-[10px] font-mono font-bold text-yellow-500">PAUSED</span>
+                                    )}
                                 </div>
                                 <Activity
                                     key={lastUpdates[metric.worker_id]} // Trigger re-render on update
@@ -93,7 +101,7 @@ export const SystemHealth: React.FC<SystemHealthProps> = ({ healthData }) => {
                                             <Zap size={10} /> MEM {metric.ram_used_mb ? `(${metric.ram_used_mb.toFixed(1)} MB)` : ''}
                                         </span>
                                         <span className="text-white font-bold">
-                                            {metric.ram_usage.toFixed(1)}% <span className="text-gray-600 text-[10px]">of 512MB</span>
+                                            {metric.ram_usage.toFixed(1)}% <span className="text-gray-600 text-[10px]">of {(metric.ram_limit_mb ?? 512).toFixed(0)}MB</span>
                                         </span>
                                     </div>
                                     <div className="h-1.5 bg-gray-800 rounded-full overflow-hidden">
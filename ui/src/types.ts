@@ -2,7 +2,8 @@ export const TaskStatus = {
     Pending: "pending",
     Processing: "running",
     Completed: "completed",
-    Failed: "failed"
+    Failed: "failed",
+    Cancelled: "cancelled"
 } as const;
 export type TaskStatus = typeof TaskStatus[keyof typeof TaskStatus];
 
@@ -21,8 +22,12 @@ export interface Task {
     payload: Record<string, any>;
     created_at: string;
     updated_at: string;
+    retry_count?: number;
     worker_id?: string;
-    result?: string;
+    result?: Record<string, any>;
+    result_text?: string;
+    started_at?: string;
+    finished_at?: string;
 }
 
 export interface WebSocketMessage {
@@ -32,13 +37,30 @@ export interface WebSocketMessage {
 
 export interface SystemHealthMetric {
     type: "HEALTH_METRIC";
-    worker_id: number;
+    worker_id: string;
     cpu_usage: number;
     ram_usage: number;
     ram_used_mb: number;
+    ram_limit_mb?: number;
+    paused?: boolean;
+    concurrency?: number;
+    running?: number;
+    timestamp: string;
+}
+
+export interface ScalingEvent {
+    type: "SCALING_EVENT";
+    worker_id: string;
+    from: number;
+    to: number;
+    reason: string;
+    queue_depth: number;
+    cpu_usage: number;
+    ram_usage: number;
     timestamp: string;
 }
 
 export type WebSocketPayload =
     | { type: "HEALTH_UPDATE"; data: SystemHealthMetric; timestamp: string }
+    | ScalingEvent
     | { type: undefined;[key: string]: any }; // For existing tasks